@@ -0,0 +1,133 @@
+package nbtee2
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// A Breaker watches a Tee's aggregate drop rate and reacts when
+// readers as a whole aren't keeping up, e.g., by telling the producer
+// to lower its bitrate or pause, instead of letting the overload get
+// worse.
+//
+// The drop rate is the fraction of deliveries (writes times attached
+// readers) during one Interval that were dropped.
+type Breaker struct {
+	Tee *Tee
+
+	// Drop rate, between 0 and 1, above which the breaker trips.
+	Threshold float64
+
+	// How often to measure the drop rate. Zero means 1s.
+	Interval time.Duration
+
+	// Tripped, if not nil, is called with the drop rate when the
+	// breaker trips. It is not called again until the breaker has
+	// reset.
+	Tripped func(rate float64)
+
+	// Reset, if not nil, is called with the drop rate when the
+	// breaker resets, i.e., the first time the drop rate is at or
+	// below Threshold after tripping.
+	Reset func(rate float64)
+
+	// Shed, if nonzero, is the number of readers to close each time
+	// the drop rate is measured above Threshold: the ones that
+	// dropped the most during the interval. Their consumers see EOF
+	// after reading what's buffered, and can reconnect (see Dial)
+	// once the overload has passed.
+	Shed int
+
+	tripped bool
+	writes  int64
+	dropped map[uint64]int64
+}
+
+// Run measures the drop rate until ctx is cancelled, then returns
+// ctx.Err().
+func (b *Breaker) Run(ctx context.Context) error {
+	interval := b.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	b.check()
+	for {
+		if err := b.Tee.sleep(ctx, interval); err != nil {
+			return err
+		}
+		b.check()
+	}
+}
+
+// Measure the drop rate since the last check and react to it.
+func (b *Breaker) check() {
+	st := b.Tee.Stats()
+	first := b.dropped == nil
+	writes := st.Writes - b.writes
+	dropped := make(map[uint64]int64, len(st.Readers))
+	type delta struct {
+		id      uint64
+		dropped int64
+	}
+	var deltas []delta
+	var total int64
+	for _, rs := range st.Readers {
+		dropped[rs.ID] = rs.Dropped
+		if d := rs.Dropped - b.dropped[rs.ID]; d > 0 {
+			deltas = append(deltas, delta{rs.ID, d})
+			total += d
+		}
+	}
+	b.writes, b.dropped = st.Writes, dropped
+	if first {
+		return
+	}
+	var rate float64
+	if writes > 0 && len(st.Readers) > 0 {
+		rate = float64(total) / float64(writes*int64(len(st.Readers)))
+		if rate > 1 {
+			rate = 1
+		}
+	}
+	if rate <= b.Threshold {
+		if b.tripped {
+			b.tripped = false
+			if b.Reset != nil {
+				b.Reset(rate)
+			}
+		}
+		return
+	}
+	if !b.tripped {
+		b.tripped = true
+		if b.Tripped != nil {
+			b.Tripped(rate)
+		}
+	}
+	if b.Shed > 0 {
+		sort.Slice(deltas, func(i, j int) bool {
+			return deltas[i].dropped > deltas[j].dropped
+		})
+		for i := 0; i < b.Shed && i < len(deltas); i++ {
+			b.Tee.logf("nbtee2: breaker: drop rate %.3f, shedding reader %d", rate, deltas[i].id)
+			b.Tee.closeReader(deltas[i].id)
+		}
+	}
+}
+
+// Close the reader with the given ID, if it is still attached.
+func (w *Tee) closeReader(id uint64) {
+	w.mtx.Lock()
+	var found *Reader
+	for r := range w.readers {
+		if r.id == id {
+			found = r
+			break
+		}
+	}
+	w.mtx.Unlock()
+	if found != nil {
+		found.Close()
+	}
+}
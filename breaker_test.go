@@ -0,0 +1,39 @@
+package nbtee2
+
+import (
+	"io/ioutil"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestBreaker(c *check.C) {
+	w := &Tee{}
+	fast := w.NewReader(0, 64)
+	defer fast.Close()
+	slow := w.NewReader(0, 1)
+	var tripped, reset []float64
+	b := &Breaker{
+		Tee:       w,
+		Threshold: 0.25,
+		Shed:      1,
+		Tripped:   func(rate float64) { tripped = append(tripped, rate) },
+		Reset:     func(rate float64) { reset = append(reset, rate) },
+	}
+	b.check()
+	for i := 0; i < 4; i++ {
+		w.Write([]byte{byte(i)})
+	}
+	b.check()
+	c.Check(tripped, check.DeepEquals, []float64{0.375})
+	c.Check(reset, check.HasLen, 0)
+	c.Check(w.Stats().Readers, check.HasLen, 1)
+	// The shed reader gets what it had buffered, then EOF.
+	buf, err := ioutil.ReadAll(slow)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.DeepEquals, []byte{0})
+
+	w.Write([]byte{4})
+	b.check()
+	c.Check(tripped, check.HasLen, 1)
+	c.Check(reset, check.DeepEquals, []float64{0.0})
+}
@@ -0,0 +1,118 @@
+package nbtee2
+
+import (
+	"container/heap"
+	"sort"
+	"sync/atomic"
+)
+
+// WithPriority sets the reader's weight for reclaiming buffered
+// writes when the Tee's MaxBuffered budget is exceeded. Readers with
+// lower weights lose their buffered writes first; readers with higher
+// weights keep theirs unless reclaiming from all lower-weight readers
+// isn't enough. Among readers with the same weight, those with the
+// most buffered data lose writes first. The default weight is 0.
+func WithPriority(weight int) ReaderOption {
+	return func(r *Reader) {
+		r.priority = weight
+	}
+}
+
+// Make room for m within w.MaxBuffered by dropping readers' oldest
+// buffered writes, lowest priority first. Within a priority, writes
+// are taken from whichever reader has the most buffered bytes, so the
+// loss is shared by the readers using most of the budget instead of
+// falling on whichever one happens to come first. Caller must have
+// w.mtx.
+func (w *Tee) reclaim(m *message) {
+	var total int64
+	for r := range w.readers {
+		total += atomic.LoadInt64(&r.bufferedBytes)
+	}
+	need := total + int64(len(m.buf))*int64(len(w.readers)) - w.MaxBuffered
+	if need <= 0 {
+		return
+	}
+	victims := make([]*Reader, 0, len(w.readers))
+	for r := range w.readers {
+		if r.buffered() > 0 {
+			victims = append(victims, r)
+		}
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		return victims[i].priority < victims[j].priority
+	})
+	for i := 0; i < len(victims) && need > 0; {
+		j := i + 1
+		for j < len(victims) && victims[j].priority == victims[i].priority {
+			j++
+		}
+		need = reclaimFair(victims[i:j], need)
+		i = j
+	}
+}
+
+// Drop writes from the given readers, always from the one with the
+// most buffered bytes, until need bytes are freed or the readers'
+// buffers are empty. Return the number of bytes still needed.
+func reclaimFair(readers []*Reader, need int64) int64 {
+	h := readerHeap(readers)
+	heap.Init(&h)
+	for need > 0 && len(h) > 0 {
+		m := h[0].discardOldest()
+		if m == nil {
+			heap.Pop(&h)
+			continue
+		}
+		need -= int64(len(m.buf))
+		heap.Fix(&h, 0)
+	}
+	return need
+}
+
+// A readerHeap is a max-heap of readers by buffered bytes.
+type readerHeap []*Reader
+
+func (h readerHeap) Len() int { return len(h) }
+func (h readerHeap) Less(i, j int) bool {
+	return atomic.LoadInt64(&h[i].bufferedBytes) > atomic.LoadInt64(&h[j].bufferedBytes)
+}
+func (h readerHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *readerHeap) Push(x interface{}) { *h = append(*h, x.(*Reader)) }
+func (h *readerHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// Drop the oldest write in r's channels, if any, and return it. This
+// is safe to call while r's consumer is reading. Caller must have
+// w.mtx.
+func (r *Reader) discardOldest() *message {
+	if m := r.discardFrom(r.rch); m != nil {
+		return m
+	}
+	for _, p := range r.pending {
+		if m := r.discardFrom(p.ch); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// Drop the next write in ch, one of r's channels, if any, and return
+// it.
+func (r *Reader) discardFrom(ch chan *message) *message {
+	select {
+	case m, ok := <-ch:
+		if !ok {
+			return nil
+		}
+		atomic.AddInt64(&r.bufferedBytes, -int64(len(m.buf)))
+		r.drop(m)
+		return m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package nbtee2
+
+import (
+	"context"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestMaxBuffered(c *check.C) {
+	w := &Tee{MaxBuffered: 20}
+	low := w.newLiveReader(context.Background(), WithPriority(-1))
+	high := w.newLiveReader(context.Background(), WithPriority(10))
+	for i := 1; i <= 4; i++ {
+		w.Write([]byte{byte(i), 0, 0, 0, 0})
+	}
+	w.Close()
+	readSeqs := func(r *Reader) (seqs []uint64) {
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			seqs = append(seqs, msg.Seq)
+		}
+	}
+	c.Check(readSeqs(low), check.DeepEquals, []uint64{4})
+	c.Check(readSeqs(high), check.DeepEquals, []uint64{2, 3, 4})
+	c.Check(low.dropped, check.Equals, int64(3))
+	c.Check(high.dropped, check.Equals, int64(1))
+}
+
+func (s *Suite) TestMaxBufferedFair(c *check.C) {
+	w := &Tee{MaxBuffered: 30}
+	defer w.Close()
+	big := w.newLiveReader(context.Background())
+	for i := 1; i <= 4; i++ {
+		w.Write([]byte{byte(i), 0, 0, 0, 0})
+	}
+	small := w.newLiveReader(context.Background())
+	for i := 5; i <= 8; i++ {
+		w.Write([]byte{byte(i), 0, 0, 0, 0})
+	}
+	// The reader with the most buffered data absorbs the first
+	// losses, then both share them.
+	c.Check(big.dropped, check.Equals, int64(5))
+	c.Check(small.dropped, check.Equals, int64(1))
+	c.Check(big.bufferedBytes, check.Equals, int64(15))
+	c.Check(small.bufferedBytes, check.Equals, int64(15))
+}
+
+func (s *Suite) TestMaxBufferedAfterSetHighWater(c *check.C) {
+	w := &Tee{MaxBuffered: 10}
+	r := w.newLiveReader(context.Background())
+	w.Write([]byte{1, 0, 0, 0, 0})
+	w.Write([]byte{2, 0, 0, 0, 0})
+	// The buffered writes stay in the old channel until the
+	// consumer reads them, but still count.
+	r.SetHighWater(8)
+	c.Check(w.Stats().Readers[0].Buffered, check.Equals, 2)
+	writes, bytes, _ := w.SlowestReaderLag()
+	c.Check(writes, check.Equals, 2)
+	c.Check(bytes, check.Equals, int64(10))
+
+	w.Write([]byte{3, 0, 0, 0, 0})
+	w.Close()
+	var seqs []uint64
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			break
+		}
+		seqs = append(seqs, msg.Seq)
+	}
+	c.Check(seqs, check.DeepEquals, []uint64{2, 3})
+	c.Check(r.dropped, check.Equals, int64(1))
+}
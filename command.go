@@ -0,0 +1,83 @@
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// RestartPolicy determines whether a CommandSource restarts its
+// process after it exits.
+type RestartPolicy int
+
+const (
+	RestartNever     RestartPolicy = iota // don't restart
+	RestartOnFailure                      // restart if the process fails
+	RestartAlways                         // restart even if the process exits 0
+)
+
+// A CommandSource runs a subprocess (e.g., an encoder) and sends its
+// standard output to a Tee, optionally sending its standard error to
+// a second Tee, and restarting it according to a RestartPolicy.
+type CommandSource struct {
+	// Command returns a new command to run each time the process
+	// is started, e.g.:
+	//
+	//	func(ctx context.Context) *exec.Cmd {
+	//		return exec.CommandContext(ctx, "ffmpeg", "-i", src, "-f", "mpegts", "-")
+	//	}
+	//
+	// Its Stdout and Stderr fields are set by CommandSource. Using
+	// exec.CommandContext with the given ctx ensures the process
+	// is killed when Run returns.
+	Command func(ctx context.Context) *exec.Cmd
+
+	Stdout *Tee
+	Stderr *Tee // if nil, stderr is discarded
+
+	Restart RestartPolicy
+
+	// Delay before restarting. Default 1s.
+	RestartDelay time.Duration
+
+	// Exited, if not nil, is called each time the process exits,
+	// with the error returned by (*exec.Cmd).Wait.
+	Exited func(error)
+}
+
+// Run starts the process and runs it (restarting as needed) until ctx
+// is cancelled or the restart policy says to stop. It returns
+// ctx.Err() if ctx is cancelled, otherwise the error returned by the
+// last (*exec.Cmd).Run. Run does not close the Tees.
+func (cs *CommandSource) Run(ctx context.Context) error {
+	delay := cs.RestartDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+	for {
+		cmd := cs.Command(ctx)
+		cmd.Stdout = cs.Stdout
+		if cs.Stderr != nil {
+			cmd.Stderr = cs.Stderr
+		}
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if cs.Exited != nil {
+			cs.Exited(err)
+		}
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			// Failed to start at all.
+			return err
+		}
+		if cs.Restart == RestartNever || (cs.Restart == RestartOnFailure && err == nil) {
+			return err
+		}
+		if err := cs.Stdout.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package nbtee2
+
+import (
+	"context"
+	"os/exec"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestCommandSource(c *check.C) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		c.Skip("no sh")
+	}
+	out, errs := &Tee{}, &Tee{}
+	rOut, rErr := out.NewReader(0, 64), errs.NewReader(0, 64)
+	var exits []error
+	cs := &CommandSource{
+		Command: func(ctx context.Context) *exec.Cmd {
+			return exec.CommandContext(ctx, "sh", "-c", "echo out; echo err >&2; exit 3")
+		},
+		Stdout:       out,
+		Stderr:       errs,
+		Restart:      RestartOnFailure,
+		RestartDelay: time.Millisecond,
+		Exited:       func(err error) { exits = append(exits, err) },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- cs.Run(ctx) }()
+	for i := 0; i < 2; i++ {
+		msg, err := rOut.ReadMessage()
+		c.Check(err, check.IsNil)
+		c.Check(string(msg.Data), check.Equals, "out\n")
+		msg, err = rErr.ReadMessage()
+		c.Check(err, check.IsNil)
+		c.Check(string(msg.Data), check.Equals, "err\n")
+	}
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+	c.Check(len(exits) >= 1, check.Equals, true)
+	c.Check(exits[0], check.ErrorMatches, "exit status 3")
+}
+
+func (s *Suite) TestCommandSourceNoRestart(c *check.C) {
+	if _, err := exec.LookPath("true"); err != nil {
+		c.Skip("no true")
+	}
+	cs := &CommandSource{
+		Command: func(ctx context.Context) *exec.Cmd { return exec.CommandContext(ctx, "true") },
+		Stdout:  &Tee{},
+		Restart: RestartOnFailure,
+	}
+	c.Check(cs.Run(context.Background()), check.IsNil)
+
+	cs.Command = func(ctx context.Context) *exec.Cmd { return exec.CommandContext(ctx, "/nonexistent") }
+	cs.Restart = RestartAlways
+	c.Check(cs.Run(context.Background()), check.NotNil)
+}
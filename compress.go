@@ -0,0 +1,81 @@
+package nbtee2
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A Compression is a content coding that StreamHandler can apply to
+// each client's response. Each client gets its own compressor, so
+// compression costs CPU per client, not per write.
+//
+// Gzip is built in. The nbteezstd module provides zstd, optionally
+// with a dictionary trained on the stream's records. Other codings
+// can be plugged in with a third-party compressor.
+type Compression struct {
+	// Content coding, as used in Accept-Encoding and
+	// Content-Encoding headers.
+	Name string
+
+	// NewWriter returns a compressor that writes to w.
+	NewWriter func(w io.Writer) (CompressWriter, error)
+}
+
+// A CompressWriter compresses data written to it. Flush must write
+// everything written so far in a form the client can decompress
+// without waiting for more, and Close must finish the stream (but not
+// close the underlying writer).
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Gzip returns a gzip Compression with the given level (see
+// compress/gzip).
+func Gzip(level int) Compression {
+	return Compression{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) (CompressWriter, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	}
+}
+
+// Return the first of the given compressions accepted by req, or nil
+// if none are.
+func negotiateCompression(req *http.Request, comps []Compression) *Compression {
+	if len(comps) == 0 {
+		return nil
+	}
+	accepted := map[string]bool{}
+	wildcard := false
+	for _, hdr := range req.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(hdr, ",") {
+			params := strings.Split(item, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			ok := true
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					ok = err == nil && q > 0
+				}
+			}
+			if coding == "*" {
+				wildcard = ok
+			} else {
+				accepted[coding] = ok
+			}
+		}
+	}
+	for i, comp := range comps {
+		ok, listed := accepted[strings.ToLower(comp.Name)]
+		if ok || (!listed && wildcard) {
+			return &comps[i]
+		}
+	}
+	return nil
+}
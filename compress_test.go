@@ -0,0 +1,70 @@
+package nbtee2
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestStreamHandlerGzip(c *check.C) {
+	w := &Tee{}
+	srv := httptest.NewServer(&StreamHandler{
+		Tee:         w,
+		Compression: []Compression{Gzip(gzip.BestSpeed)},
+	})
+	defer srv.Close()
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.5")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	c.Check(resp.Header.Get("Content-Encoding"), check.Equals, "gzip")
+	c.Check(resp.Header.Get("Vary"), check.Equals, "Accept-Encoding")
+	waitReaders(c, w, 1)
+
+	w.Write([]byte("foo"))
+	zr, err := gzip.NewReader(resp.Body)
+	c.Assert(err, check.IsNil)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(zr, buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+	w.Write([]byte("bar"))
+	w.Close()
+	rest, err := ioutil.ReadAll(zr)
+	c.Check(err, check.IsNil)
+	c.Check(string(rest), check.Equals, "bar")
+}
+
+func (s *Suite) TestNegotiateCompression(c *check.C) {
+	comps := []Compression{{Name: "zstd"}, {Name: "gzip"}}
+	for _, trial := range []struct {
+		accept string
+		expect string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, zstd", "zstd"},
+		{"GZIP, zstd;q=0", "gzip"},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "gzip"},
+		{"*;q=0", ""},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if trial.accept != "" {
+			req.Header.Set("Accept-Encoding", trial.accept)
+		}
+		comp := negotiateCompression(req, comps)
+		if trial.expect == "" {
+			c.Check(comp, check.IsNil, check.Commentf("%q", trial.accept))
+		} else if c.Check(comp, check.NotNil, check.Commentf("%q", trial.accept)) {
+			c.Check(comp.Name, check.Equals, trial.expect)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// A ConnSet streams everything sent to a Tee to a changing set of
+// connections that were established elsewhere (e.g., accepted by a
+// custom protocol handshake). A connection whose writes block for
+// longer than WriteTimeout is disconnected, so a stuck client can't
+// tie up resources indefinitely.
+type ConnSet struct {
+	Tee *Tee
+
+	// Maximum time a single write to a connection may take. Zero
+	// means no limit.
+	WriteTimeout time.Duration
+
+	// Options for each connection's reader.
+	ReaderOptions []ReaderOption
+
+	// Disconnected, if not nil, is called with each connection's
+	// session stats after it is closed.
+	Disconnected func(ConnStats)
+
+	mtx      sync.Mutex
+	conns    map[net.Conn]context.CancelFunc
+	wg       sync.WaitGroup
+	sessions sessions
+}
+
+// Add starts streaming to conn in a new goroutine. The ConnSet closes
+// conn when the Tee is closed, the client hangs up, a write fails or
+// times out, or Remove or Close is called.
+func (cs *ConnSet) Add(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cs.mtx.Lock()
+	if cs.conns == nil {
+		cs.conns = map[net.Conn]context.CancelFunc{}
+	}
+	cs.conns[conn] = cancel
+	cs.wg.Add(1)
+	cs.mtx.Unlock()
+	go func() {
+		defer cs.wg.Done()
+		st := streamToConn(ctx, cs.Tee, conn, cs.WriteTimeout, cs.ReaderOptions, &cs.sessions)
+		cancel()
+		cs.mtx.Lock()
+		delete(cs.conns, conn)
+		cs.mtx.Unlock()
+		if cs.Disconnected != nil {
+			cs.Disconnected(st)
+		}
+	}()
+}
+
+// Remove disconnects conn, if it is in the set.
+func (cs *ConnSet) Remove(conn net.Conn) {
+	cs.mtx.Lock()
+	cancel := cs.conns[conn]
+	cs.mtx.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Len returns the number of connections in the set.
+func (cs *ConnSet) Len() int {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	return len(cs.conns)
+}
+
+// Shutdown stops streaming new writes to the connections, lets each
+// one finish sending what its reader has buffered, and waits for them
+// to close. If ctx is done first, the remaining connections are
+// closed immediately and Shutdown returns ctx.Err(). Connections
+// added after Shutdown are closed right away.
+func (cs *ConnSet) Shutdown(ctx context.Context) error {
+	return cs.sessions.drain(ctx)
+}
+
+// Close disconnects all connections and waits for their goroutines
+// to finish.
+func (cs *ConnSet) Close() error {
+	cs.mtx.Lock()
+	for _, cancel := range cs.conns {
+		cancel()
+	}
+	cs.mtx.Unlock()
+	cs.wg.Wait()
+	return nil
+}
+
+// Stream from a new reader on w to conn until ctx is cancelled, the
+// client hangs up, an error occurs, or ss is drained. Close conn and
+// return the session stats.
+func streamToConn(ctx context.Context, w *Tee, conn net.Conn, timeout time.Duration, opts []ReaderOption, ss *sessions) ConnStats {
+	defer conn.Close()
+	start := w.now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := w.newLiveReader(ctx, opts...)
+	defer r.Close()
+	if !ss.add(r, cancel) {
+		return connStats(conn.RemoteAddr().String(), start, r, 0, ErrShutdown)
+	}
+	defer ss.done(r)
+	go func() {
+		// Stop when the client hangs up, even if there is
+		// nothing to send.
+		io.Copy(ioutil.Discard, conn)
+		cancel()
+	}()
+	go func() {
+		// Unblock a pending write if ctx is cancelled.
+		<-ctx.Done()
+		conn.Close()
+	}()
+	var dst io.Writer = conn
+	if timeout > 0 {
+		dst = deadlineWriter{conn, timeout}
+	}
+	n, err := r.WriteTo(dst)
+	return connStats(conn.RemoteAddr().String(), start, r, n, err)
+}
+
+// A deadlineWriter sets a write deadline before each write.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (dw deadlineWriter) Write(p []byte) (int, error) {
+	if err := dw.conn.SetWriteDeadline(time.Now().Add(dw.timeout)); err != nil {
+		return 0, err
+	}
+	return dw.conn.Write(p)
+}
@@ -0,0 +1,46 @@
+package nbtee2
+
+import (
+	"net"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestConnSet(c *check.C) {
+	w := &Tee{}
+	disconnected := make(chan ConnStats, 2)
+	cs := &ConnSet{
+		Tee:          w,
+		WriteTimeout: 20 * time.Millisecond,
+		Disconnected: func(st ConnStats) { disconnected <- st },
+	}
+	stuck, stuckClient := net.Pipe()
+	defer stuckClient.Close()
+	good, goodClient := net.Pipe()
+	defer goodClient.Close()
+	cs.Add(stuck)
+	cs.Add(good)
+	c.Check(cs.Len(), check.Equals, 2)
+	waitReaders(c, w, 2)
+
+	w.Write([]byte("foo"))
+	buf := make([]byte, 3)
+	_, err := goodClient.Read(buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+
+	// stuckClient never reads, so its write times out.
+	st := <-disconnected
+	c.Check(st.Bytes, check.Equals, int64(0))
+	netErr, ok := st.Err.(net.Error)
+	c.Assert(ok, check.Equals, true)
+	c.Check(netErr.Timeout(), check.Equals, true)
+	c.Check(cs.Len(), check.Equals, 1)
+
+	cs.Remove(good)
+	st = <-disconnected
+	c.Check(st.Bytes, check.Equals, int64(3))
+	c.Check(cs.Len(), check.Equals, 0)
+	c.Check(cs.Close(), check.IsNil)
+}
@@ -0,0 +1,82 @@
+package nbtee2
+
+// Container-aware writers (OggWriter, etc.) split a byte stream into
+// units that can be delivered or dropped independently, so a reader
+// that falls behind loses whole units instead of corrupting the
+// stream. They also capture the stream's header units, which are
+// replayed to each new reader before anything else, and mark units
+// that a decoder can't start from, which readers skip when they are
+// starting out or have just missed something.
+
+// Restart starts a new generation of the stream, for when the
+// upstream source is replaced (e.g., an encoder restarts with new
+// codec settings) but readers should carry on instead of reaching
+// EOF. Retained writes from the old generation are discarded, and
+// readers see Message.Generation change.
+//
+// The given header bufs, if any, are sent as writes, and replayed to
+// each new reader before anything else, like the header of a
+// container stream (see OggWriter). They replace the previous
+// generation's header.
+func (w *Tee) Restart(header ...[]byte) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.restartLocked()
+	for _, buf := range header {
+		m := &message{buf: append([]byte(nil), buf...), header: true}
+		m.t = w.now()
+		w.header = append(w.header, m)
+		w.broadcast(m)
+	}
+}
+
+// Start a new generation with no header. Caller must have w.mtx.
+func (w *Tee) restartLocked() {
+	w.gen++
+	w.header = nil
+	for i := range w.history {
+		w.history[i] = nil
+	}
+	w.history = w.history[:0]
+	w.expired = w.now()
+}
+
+// Send buf as a unit of a container stream. Caller must not modify
+// buf afterward.
+func (w *Tee) sendUnit(buf []byte, nosync bool) {
+	w.send(&message{buf: buf, nosync: nosync})
+}
+
+// Send buf like sendUnit, and add it to the stream header replayed
+// to new readers. If reset is true, it replaces the existing header
+// instead (e.g., a new stream is starting).
+func (w *Tee) sendHeader(buf []byte, reset bool) {
+	m := &message{buf: buf, header: true}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	m.t = w.now()
+	if reset {
+		w.restartLocked()
+	}
+	// Copy on append: readers may share the old slice.
+	w.header = append(w.header[:len(w.header):len(w.header)], m)
+	w.broadcast(m)
+}
+
+// Send buf like sendHeader, replacing the i'th unit of the stream
+// header (e.g., the codec configuration has changed), or adding it if
+// the header has fewer than i+1 units.
+func (w *Tee) replaceHeader(i int, buf []byte) {
+	m := &message{buf: buf, header: true}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	m.t = w.now()
+	header := append([]*message(nil), w.header...)
+	if i < len(header) {
+		header[i] = m
+	} else {
+		header = append(header, m)
+	}
+	w.header = header
+	w.broadcast(m)
+}
@@ -0,0 +1,50 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestRestart(c *check.C) {
+	w := New(WithRetain(time.Minute))
+	r1 := w.newLiveReader(context.Background())
+	w.Write([]byte("a"))
+	w.Write([]byte("b"))
+	w.Restart([]byte("hdr"))
+	w.Write([]byte("c"))
+	c.Check(w.Snapshot(), check.DeepEquals, [][]byte{[]byte("hdr"), []byte("c")})
+	r2 := w.NewReaderAt(context.Background(), time.Time{})
+	w.Write([]byte("d"))
+	w.Close()
+
+	type result struct {
+		data string
+		gen  uint64
+		gap  bool
+	}
+	readAll := func(r *Reader) (got []result) {
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				c.Check(err, check.Equals, io.EOF)
+				return
+			}
+			got = append(got, result{string(msg.Data), msg.Generation, msg.Gap})
+		}
+	}
+	c.Check(readAll(r1), check.DeepEquals, []result{
+		{"a", 0, false},
+		{"b", 0, false},
+		{"hdr", 1, false},
+		{"c", 1, false},
+		{"d", 1, false},
+	})
+	c.Check(readAll(r2), check.DeepEquals, []result{
+		{"hdr", 1, false},
+		{"c", 1, false},
+		{"d", 1, false},
+	})
+}
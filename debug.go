@@ -0,0 +1,48 @@
+package nbtee2
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DebugState returns a snapshot of w's internal state -- counters,
+// the retention window, and each reader's watermarks, buffer depth,
+// and drop counts -- suitable for logging, encoding as JSON, or
+// including in a bug report.
+//
+// The keys are meant for humans and may change between versions.
+func (w *Tee) DebugState() map[string]interface{} {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	now := w.now()
+	readers := make([]map[string]interface{}, 0, len(w.readers))
+	for _, r := range w.sortedReaders() {
+		rs := r.stats(now)
+		readers = append(readers, map[string]interface{}{
+			"id":             rs.ID,
+			"lowwater":       int(atomic.LoadInt32(&r.lowwater)),
+			"highwater":      r.highwater,
+			"buffered":       rs.Buffered,
+			"buffered_bytes": rs.BufferedBytes,
+			"dropped":        rs.Dropped,
+			"dropped_bytes":  rs.DroppedBytes,
+			"stalled":        rs.Stalled.String(),
+			"last_read":      rs.LastRead.UTC().Format(time.RFC3339Nano),
+		})
+		if !rs.LastWrite.IsZero() {
+			readers[len(readers)-1]["last_write"] = rs.LastWrite.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	state := map[string]interface{}{
+		"time":     now.UTC().Format(time.RFC3339Nano),
+		"writes":   w.writes,
+		"bytes":    w.bytes,
+		"retain":   w.Retain.String(),
+		"retained": len(w.history),
+		"readers":  readers,
+	}
+	if len(w.history) > 0 {
+		state["retained_since"] = w.history[0].t.UTC().Format(time.RFC3339Nano)
+	}
+	return state
+}
@@ -0,0 +1,28 @@
+package nbtee2
+
+import (
+	"encoding/json"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestDebugState(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(2, 1)
+	defer r.Close()
+	w.Write([]byte{1, 2, 3})
+	w.Write([]byte{4})
+
+	state := w.DebugState()
+	c.Check(state["writes"], check.Equals, int64(2))
+	c.Check(state["bytes"], check.Equals, int64(4))
+	readers := state["readers"].([]map[string]interface{})
+	c.Assert(readers, check.HasLen, 1)
+	c.Check(readers[0]["lowwater"], check.Equals, 2)
+	c.Check(readers[0]["highwater"], check.Equals, 1)
+	c.Check(readers[0]["buffered"], check.Equals, 1)
+	c.Check(readers[0]["dropped"], check.Equals, int64(1))
+
+	_, err := json.Marshal(state)
+	c.Check(err, check.IsNil)
+}
@@ -0,0 +1,90 @@
+package nbtee2
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DebugHandler is an http.Handler that reports the live state of a
+// set of Tees: readers, buffer depth (lag), drops, and throughput.
+// It responds with JSON if the request has "format=json" in its
+// query string or prefers application/json, otherwise HTML.
+//
+// It is meant to be mounted next to /debug/pprof:
+//
+//	http.Handle("/debug/nbtee", &nbtee2.DebugHandler{
+//		Tees: func() map[string]*nbtee2.Tee {
+//			return map[string]*nbtee2.Tee{"camera1": tee}
+//		},
+//	})
+type DebugHandler struct {
+	// Tees returns the Tees to report, by name. It is called once
+	// per request.
+	Tees func() map[string]*Tee
+}
+
+// DebugTeeState is the JSON representation of each Tee reported by
+// DebugHandler.
+type DebugTeeState struct {
+	Name string
+	Stats
+	// Average since the first write.
+	WritesPerSecond float64
+	BytesPerSecond  float64
+}
+
+// ServeHTTP implements http.Handler.
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var tees map[string]*Tee
+	if h.Tees != nil {
+		tees = h.Tees()
+	}
+	names := make([]string, 0, len(tees))
+	for name := range tees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	states := make([]DebugTeeState, 0, len(names))
+	for _, name := range names {
+		tee := tees[name]
+		st := DebugTeeState{Name: name, Stats: tee.Stats()}
+		// Started is on the Tee's clock (see WithClock).
+		if secs := tee.now().Sub(st.Started).Seconds(); !st.Started.IsZero() && secs > 0 {
+			st.WritesPerSecond = float64(st.Writes) / secs
+			st.BytesPerSecond = float64(st.Bytes) / secs
+		}
+		states = append(states, st)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if req.FormValue("format") == "json" || strings.HasPrefix(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(states)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	debugTemplate.Execute(w, states)
+}
+
+var debugTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", 100*f) },
+	"rate":    func(f float64) string { return fmt.Sprintf("%.1f", f) },
+}).Parse(`<!DOCTYPE html>
+<html><head><title>nbtee</title></head><body>
+{{range .}}
+<h2>{{.Name}}</h2>
+<p>{{.Writes}} writes, {{.Bytes}} bytes ({{rate .WritesPerSecond}} writes/s, {{rate .BytesPerSecond}} bytes/s), {{.Retained}} retained</p>
+<table border="1" cellpadding="3">
+<tr><th>reader</th><th>buffered</th><th>capacity</th><th>fill</th><th>buffered bytes</th><th>dropped</th><th>dropped bytes</th><th>stalled</th></tr>
+{{range .Readers}}<tr><td>{{.ID}}</td><td>{{.Buffered}}</td><td>{{.Capacity}}</td><td>{{percent .Fill}}</td><td>{{.BufferedBytes}}</td><td>{{.Dropped}}</td><td>{{.DroppedBytes}}</td><td>{{.Stalled}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No tees.</p>
+{{end}}
+</body></html>
+`))
@@ -0,0 +1,57 @@
+package nbtee2
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestDebugHandler(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	defer r.Close()
+	w.Write([]byte("hello"))
+	h := &DebugHandler{Tees: func() map[string]*Tee {
+		return map[string]*Tee{"main": w, "other": {}}
+	}}
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/debug/nbtee?format=json", nil))
+	c.Check(resp.Header().Get("Content-Type"), check.Equals, "application/json")
+	var states []DebugTeeState
+	c.Assert(json.Unmarshal(resp.Body.Bytes(), &states), check.IsNil)
+	c.Assert(states, check.HasLen, 2)
+	c.Check(states[0].Name, check.Equals, "main")
+	c.Check(states[0].Bytes, check.Equals, int64(5))
+	c.Check(states[0].BytesPerSecond > 0, check.Equals, true)
+	c.Assert(states[0].Readers, check.HasLen, 1)
+	c.Check(states[0].Readers[0].BufferedBytes, check.Equals, int64(5))
+	c.Check(states[1].Name, check.Equals, "other")
+	c.Check(states[1].Readers, check.HasLen, 0)
+
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/debug/nbtee", nil))
+	c.Check(resp.Header().Get("Content-Type"), check.Matches, "text/html.*")
+	c.Check(strings.Contains(resp.Body.String(), "<h2>main</h2>"), check.Equals, true)
+	c.Check(strings.Contains(resp.Body.String(), "<td>25%</td>"), check.Equals, true)
+}
+
+func (s *Suite) TestDebugHandlerClock(c *check.C) {
+	clock := &fakeClock{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	w := New(WithClock(clock))
+	for i := 0; i < 20; i++ {
+		w.Write([]byte("hello"))
+	}
+	clock.t = clock.t.Add(10 * time.Second)
+	h := &DebugHandler{Tees: func() map[string]*Tee { return map[string]*Tee{"main": w} }}
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/debug/nbtee?format=json", nil))
+	var states []DebugTeeState
+	c.Assert(json.Unmarshal(resp.Body.Bytes(), &states), check.IsNil)
+	c.Assert(states, check.HasLen, 1)
+	c.Check(states[0].WritesPerSecond, check.Equals, 2.0)
+	c.Check(states[0].BytesPerSecond, check.Equals, 10.0)
+}
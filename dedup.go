@@ -0,0 +1,33 @@
+package nbtee2
+
+import (
+	"bytes"
+	"hash/fnv"
+)
+
+type dedupEntry struct {
+	hash uint64
+	m    *message
+}
+
+// Report whether m is identical to one of the last w.Dedup writes.
+// If not, remember it. Caller must have w.mtx.
+func (w *Tee) duplicate(m *message) bool {
+	h := fnv.New64a()
+	h.Write(m.buf)
+	h.Write([]byte{0})
+	h.Write([]byte(m.tag))
+	sum := h.Sum64()
+	for _, e := range w.dedup {
+		if e.hash == sum && e.m.tag == m.tag && bytes.Equal(e.m.buf, m.buf) {
+			return true
+		}
+	}
+	if len(w.dedup) < w.Dedup {
+		w.dedup = append(w.dedup, dedupEntry{})
+	}
+	// Shift out the oldest entry.
+	copy(w.dedup[1:], w.dedup)
+	w.dedup[0] = dedupEntry{sum, m}
+	return false
+}
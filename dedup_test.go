@@ -0,0 +1,31 @@
+package nbtee2
+
+import (
+	"io/ioutil"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestDedup(c *check.C) {
+	for _, trial := range []struct {
+		dedup  int
+		expect string
+	}{
+		{0, "aabababbc"},
+		{1, "abababc"},
+		{2, "abc"},
+	} {
+		w := &Tee{Dedup: trial.dedup}
+		r := w.NewReader(0, 64)
+		for _, p := range []string{"a", "a", "b", "a", "b", "a", "b", "b", "c"} {
+			w.Write([]byte(p))
+		}
+		// Same content, different tag: not a duplicate
+		w.Writer("t").Write([]byte("c"))
+		w.Close()
+		buf, err := ioutil.ReadAll(r)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, trial.expect+"c", check.Commentf("dedup %d", trial.dedup))
+		c.Check(w.Stats().Deduped, check.Equals, int64(9-len(trial.expect)))
+	}
+}
@@ -0,0 +1,59 @@
+package nbtee2
+
+// A DeltaWriter is an io.Writer that sends each write to a Tee along
+// with a delta against the previous write, for streams where each
+// write is a complete snapshot of some state that changes little
+// from one write to the next.
+//
+// Readers created with WithDeltas receive the delta instead of the
+// full write whenever they received the previous write, and the full
+// write otherwise (e.g., when they are starting, or have just missed
+// something). Other readers always receive full writes.
+//
+// The delta is computed once per write, regardless of how many
+// readers are attached. It is against the DeltaWriter's own previous
+// write, so a reader that received other writes to the Tee in
+// between (e.g., from Tee.Write) gets the full write instead.
+//
+// A DeltaWriter is not safe for concurrent use.
+type DeltaWriter struct {
+	Tee *Tee
+
+	// Diff returns an encoding of cur as a delta against prev. It
+	// must not modify prev or cur, or return an empty slice.
+	// Consumers that read from a Reader as a byte stream (rather
+	// than with ReadMessage) need an encoding that is
+	// distinguishable from a full write.
+	Diff func(prev, cur []byte) []byte
+
+	// Send every FullEvery'th write without a delta, so even
+	// delta readers periodically receive a full snapshot. Zero
+	// means only the first write.
+	FullEvery int
+
+	prev    []byte
+	prevSeq uint64
+	n       int
+}
+
+// Write implements io.Writer. The returned error is always nil.
+func (dw *DeltaWriter) Write(p []byte) (int, error) {
+	m := &message{buf: append([]byte(nil), p...)}
+	if dw.prev != nil && (dw.FullEvery <= 0 || dw.n%dw.FullEvery != 0) {
+		m.delta = dw.Diff(dw.prev, m.buf)
+		m.deltaBase = dw.prevSeq
+	}
+	dw.Tee.send(m)
+	// m.seq is assigned by send, and not changed after.
+	dw.prev, dw.prevSeq = m.buf, m.seq
+	dw.n++
+	return len(p), nil
+}
+
+// WithDeltas makes the reader receive deltas sent by a DeltaWriter
+// when it is up to date. See Message.Delta.
+func WithDeltas() ReaderOption {
+	return func(r *Reader) {
+		r.deltas = true
+	}
+}
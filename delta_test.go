@@ -0,0 +1,99 @@
+package nbtee2
+
+import (
+	"context"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestDeltaWriter(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	dw := &DeltaWriter{
+		Tee: w,
+		Diff: func(prev, cur []byte) []byte {
+			return append([]byte("delta:"), cur...)
+		},
+		FullEvery: 3,
+	}
+	f := &Faults{}
+	r1 := w.newLiveReader(context.Background(), WithDeltas(), WithFaults(f))
+	r2 := w.newLiveReader(context.Background())
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		dw.Write([]byte(s))
+	}
+	type result struct {
+		data  string
+		delta bool
+		gap   bool
+	}
+	var got1, got2 []result
+	for i := 0; i < 5; i++ {
+		msg, err := r1.ReadMessage()
+		c.Assert(err, check.IsNil)
+		got1 = append(got1, result{string(msg.Data), msg.Delta, msg.Gap})
+		msg, err = r2.ReadMessage()
+		c.Assert(err, check.IsNil)
+		got2 = append(got2, result{string(msg.Data), msg.Delta, msg.Gap})
+	}
+	c.Check(got1, check.DeepEquals, []result{
+		{"a", false, false},
+		{"delta:b", true, false},
+		{"delta:c", true, false},
+		{"d", false, false},
+		{"delta:e", true, false},
+	})
+	c.Check(got2, check.DeepEquals, []result{
+		{"a", false, false},
+		{"b", false, false},
+		{"c", false, false},
+		{"d", false, false},
+		{"e", false, false},
+	})
+
+	// Full resync after a drop.
+	dw.Write([]byte("f"))
+	f.Overflow()
+	dw.Write([]byte("g"))
+	dw.Write([]byte("h"))
+	msg, err := r1.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Check(result{string(msg.Data), msg.Delta, msg.Gap}, check.Equals, result{"g", false, true})
+	msg, err = r1.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Check(result{string(msg.Data), msg.Delta, msg.Gap}, check.Equals, result{"delta:h", true, false})
+
+	// A new reader gets a full write first.
+	r3 := w.newLiveReader(context.Background(), WithDeltas())
+	defer r3.Close()
+	dw.Write([]byte("i"))
+	msg, err = r3.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Check(result{string(msg.Data), msg.Delta, msg.Gap}, check.Equals, result{"i", false, false})
+}
+
+func (s *Suite) TestDeltaWriterInterleaved(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	dw := &DeltaWriter{
+		Tee: w,
+		Diff: func(prev, cur []byte) []byte {
+			return append([]byte("delta:"), cur...)
+		},
+	}
+	r := w.newLiveReader(context.Background(), WithDeltas())
+	dw.Write([]byte("a"))
+	w.Write([]byte("other"))
+	dw.Write([]byte("b"))
+	dw.Write([]byte("c"))
+	var got []string
+	for i := 0; i < 4; i++ {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Gap, check.Equals, false)
+		got = append(got, string(msg.Data))
+	}
+	// "b" is a delta against "a", but this reader's previous
+	// message is "other".
+	c.Check(got, check.DeepEquals, []string{"a", "other", "b", "delta:c"})
+}
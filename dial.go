@@ -0,0 +1,157 @@
+package nbtee2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// A DialOption configures a reader returned by Dial.
+type DialOption func(*dialReader)
+
+// WithHTTPClient sets the HTTP client used by Dial. The default is
+// http.DefaultClient. The client should not have an overall Timeout,
+// since a stream can last indefinitely.
+func WithHTTPClient(client *http.Client) DialOption {
+	return func(d *dialReader) { d.client = client }
+}
+
+// WithHeader adds headers (e.g., Authorization) to each request made
+// by Dial.
+func WithHeader(header http.Header) DialOption {
+	return func(d *dialReader) { d.header = header }
+}
+
+// WithBackoff sets the delay before reconnecting after a failure.
+// The first delay is min, and each consecutive failure doubles it,
+// up to max. The default is (100ms, 10s).
+func WithBackoff(min, max time.Duration) DialOption {
+	return func(d *dialReader) { d.minBackoff, d.maxBackoff = min, max }
+}
+
+// WithBackoffClock makes Dial wait for backoff delays on c instead of
+// the system clock, if c is a TimerClock.
+func WithBackoffClock(c Clock) DialOption {
+	return func(d *dialReader) { d.clock = c }
+}
+
+// Dial returns an io.ReadCloser that reads a stream served by a
+// remote StreamHandler (or any HTTP endpoint that streams a response
+// body). When the connection fails or the response ends, it
+// reconnects after a delay (see WithBackoff) and continues reading.
+//
+// A reconnected stream continues with the server's next write: like
+// any other reader, a Dial reader misses data while it isn't
+// connected. It can't resume from the last write it received,
+// because StreamHandler's response body doesn't mark where one write
+// ends and the next begins, so the client never knows which write
+// it has read up to. Clients that need to pick up where they left
+// off should use PollHandler, whose cursors identify writes in the
+// server's retention window.
+//
+// If the server responds with a 4xx status, Read returns an error
+// instead of retrying. After Close, Read returns io.EOF.
+func Dial(url string, opts ...DialOption) io.ReadCloser {
+	d := &dialReader{
+		url:        url,
+		client:     http.DefaultClient,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d
+}
+
+type dialReader struct {
+	url        string
+	client     *http.Client
+	header     http.Header
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	clock      Clock
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	body    io.ReadCloser
+	backoff time.Duration
+}
+
+func (d *dialReader) Read(p []byte) (int, error) {
+	for {
+		if d.ctx.Err() != nil {
+			return 0, io.EOF
+		}
+		if d.body == nil {
+			err := d.connect()
+			if err, ok := err.(permanentError); ok {
+				return 0, err.error
+			}
+			if err != nil {
+				d.wait()
+				continue
+			}
+		}
+		n, err := d.body.Read(p)
+		if n > 0 {
+			d.backoff = 0
+			return n, nil
+		}
+		if err != nil {
+			d.body.Close()
+			d.body = nil
+			d.wait()
+		}
+	}
+}
+
+// Close stops reading and closes the current connection, if any. It
+// is safe to call Close while a Read is in progress.
+func (d *dialReader) Close() error {
+	d.cancel()
+	return nil
+}
+
+type permanentError struct{ error }
+
+func (d *dialReader) connect() error {
+	req, err := http.NewRequest("GET", d.url, nil)
+	if err != nil {
+		return permanentError{err}
+	}
+	req = req.WithContext(d.ctx)
+	for k, v := range d.header {
+		req.Header[k] = v
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("%s: %s", d.url, resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return permanentError{err}
+		}
+		return err
+	}
+	d.body = resp.Body
+	return nil
+}
+
+// Sleep for the current backoff interval (or until Close is called)
+// and increase it for next time.
+func (d *dialReader) wait() {
+	if d.backoff < d.minBackoff {
+		d.backoff = d.minBackoff
+	}
+	clockSleep(d.ctx, d.clock, d.backoff)
+	d.backoff *= 2
+	if d.backoff > d.maxBackoff {
+		d.backoff = d.maxBackoff
+	}
+}
@@ -0,0 +1,62 @@
+package nbtee2
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestDialReconnect(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	auth := make(chan string, 10)
+	srv := httptest.NewServer(&StreamHandler{Tee: w, Authorize: func(req *http.Request) error {
+		auth <- req.Header.Get("Authorization")
+		return nil
+	}})
+	defer srv.Close()
+	r := Dial(srv.URL,
+		WithBackoff(time.Millisecond, 10*time.Millisecond),
+		WithHeader(http.Header{"Authorization": {"Bearer x"}}))
+	defer r.Close()
+
+	got := make(chan string)
+	go func() {
+		buf := make([]byte, 3)
+		for {
+			if _, err := io.ReadFull(r, buf); err != nil {
+				close(got)
+				return
+			}
+			got <- string(buf)
+		}
+	}()
+
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	c.Check(<-got, check.Equals, "foo")
+
+	srv.CloseClientConnections()
+	waitReaders(c, w, 0)
+	waitReaders(c, w, 1)
+	w.Write([]byte("bar"))
+	c.Check(<-got, check.Equals, "bar")
+	c.Check(<-auth, check.Equals, "Bearer x")
+	c.Check(<-auth, check.Equals, "Bearer x")
+
+	r.Close()
+	_, ok := <-got
+	c.Check(ok, check.Equals, false)
+}
+
+func (s *Suite) TestDialClientError(c *check.C) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	r := Dial(srv.URL)
+	defer r.Close()
+	_, err := r.Read(make([]byte, 1))
+	c.Check(err, check.ErrorMatches, ".*404 Not Found")
+}
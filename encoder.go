@@ -0,0 +1,51 @@
+package nbtee2
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// An EncoderTee encodes values and sends the encoded bytes to a Tee
+// as individual writes. Each value is encoded once, regardless of how
+// many readers are attached.
+type EncoderTee struct {
+	Tee *Tee
+
+	// Encode returns the encoding of a value, e.g., EncodeJSON,
+	// EncodeGob, or a third party function like msgpack.Marshal.
+	// If nil, EncodeJSON is used.
+	Encode func(interface{}) ([]byte, error)
+}
+
+// Send encodes v and writes the result to et.Tee. If the encoder
+// returns an error, nothing is written.
+func (et *EncoderTee) Send(v interface{}) error {
+	encode := et.Encode
+	if encode == nil {
+		encode = EncodeJSON
+	}
+	buf, err := encode(v)
+	if err != nil {
+		return err
+	}
+	et.Tee.send(&message{buf: buf})
+	return nil
+}
+
+// EncodeJSON returns the JSON encoding of v followed by a newline,
+// as with json.Encoder.
+func EncodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// EncodeGob returns the gob encoding of v. Each value is encoded
+// with a new gob.Encoder, so it includes its own type information
+// and readers can decode it without having seen earlier writes.
+func EncodeGob(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,42 @@
+package nbtee2
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	check "gopkg.in/check.v1"
+)
+
+type testEvent struct {
+	Name  string
+	Count int
+}
+
+func (s *Suite) TestEncoderTeeJSON(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 16)
+	et := &EncoderTee{Tee: w}
+	c.Check(et.Send(testEvent{"a", 1}), check.IsNil)
+	c.Check(et.Send(func() {}), check.NotNil)
+	w.Close()
+	msg, err := r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, `{"Name":"a","Count":1}`+"\n")
+	_, err = r.ReadMessage()
+	c.Check(err, check.NotNil)
+}
+
+func (s *Suite) TestEncoderTeeGob(c *check.C) {
+	w := &Tee{}
+	et := &EncoderTee{Tee: w, Encode: EncodeGob}
+	et.Send(testEvent{"a", 1})
+	// A reader that joins late can still decode.
+	r := w.NewReader(0, 16)
+	et.Send(testEvent{"b", 2})
+	w.Close()
+	msg, err := r.ReadMessage()
+	c.Assert(err, check.IsNil)
+	var ev testEvent
+	c.Check(gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(&ev), check.IsNil)
+	c.Check(ev, check.DeepEquals, testEvent{"b", 2})
+}
@@ -0,0 +1,92 @@
+package nbtee2
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Below this many readers, broadcast delivers inline even if
+// FanoutWorkers is set: handing off to workers costs more than it
+// saves.
+const fanoutMinReaders = 64
+
+type fanoutJob struct {
+	m       *message
+	readers []*Reader
+}
+
+// A fanoutPool is a set of goroutines that deliver messages to
+// readers on behalf of broadcast.
+type fanoutPool struct {
+	delivered int64 // updated atomically during each broadcast
+	dropped   int64 // updated atomically during each broadcast
+	jobs      chan fanoutJob
+	wg        sync.WaitGroup // outstanding jobs
+	readers   []*Reader      // reused by each broadcast
+}
+
+func newFanoutPool(workers int) *fanoutPool {
+	p := &fanoutPool{jobs: make(chan fanoutJob, workers)}
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+func (p *fanoutPool) run() {
+	for job := range p.jobs {
+		for _, r := range job.readers {
+			p.count(r.offer(job.m))
+		}
+		p.wg.Done()
+	}
+}
+
+// Deliver m to w's readers using w's worker pool, wait for all
+// deliveries to finish, and return the number of readers that
+// received m and the number that dropped it. The calling goroutine
+// does one share of the work itself. Caller must have w.mtx.
+func (w *Tee) fanoutBroadcast(m *message) (delivered, dropped int) {
+	if w.fanout == nil {
+		w.fanout = newFanoutPool(w.FanoutWorkers - 1)
+	}
+	p := w.fanout
+	p.delivered, p.dropped = 0, 0
+	p.readers = p.readers[:0]
+	for r := range w.readers {
+		p.readers = append(p.readers, r)
+	}
+	chunk := (len(p.readers) + w.FanoutWorkers - 1) / w.FanoutWorkers
+	todo := p.readers
+	for len(todo) > chunk {
+		p.wg.Add(1)
+		p.jobs <- fanoutJob{m: m, readers: todo[:chunk]}
+		todo = todo[chunk:]
+	}
+	for _, r := range todo {
+		p.count(r.offer(m))
+	}
+	p.wg.Wait()
+	for i := range p.readers {
+		p.readers[i] = nil
+	}
+	return int(p.delivered), int(p.dropped)
+}
+
+// Count the outcome of one offer.
+func (p *fanoutPool) count(res offerResult) {
+	switch res {
+	case offerDelivered:
+		atomic.AddInt64(&p.delivered, 1)
+	case offerDropped:
+		atomic.AddInt64(&p.dropped, 1)
+	}
+}
+
+// Stop the worker goroutines. Caller must have w.mtx.
+func (w *Tee) stopFanout() {
+	if w.fanout != nil {
+		close(w.fanout.jobs)
+		w.fanout = nil
+	}
+}
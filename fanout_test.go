@@ -0,0 +1,58 @@
+package nbtee2
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestFanoutWorkers(c *check.C) {
+	w := &Tee{FanoutWorkers: 4}
+	var wg sync.WaitGroup
+	readers := make([]*Reader, fanoutMinReaders*3+1)
+	got := make([][]byte, len(readers))
+	for i := range readers {
+		readers[i] = w.NewReader(0, 16)
+	}
+	for i, r := range readers {
+		i, r := i, r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i], _ = ioutil.ReadAll(r)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		delivered, dropped := w.TryWrite([]byte{byte(i)})
+		c.Check(delivered, check.Equals, len(readers))
+		c.Check(dropped, check.Equals, 0)
+	}
+	c.Check(w.fanout, check.NotNil)
+	w.Close()
+	wg.Wait()
+	c.Check(w.fanout, check.IsNil)
+	for i := range got {
+		c.Check(got[i], check.DeepEquals, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	}
+}
+
+func (s *Suite) TestFanoutSampled(c *check.C) {
+	w := &Tee{FanoutWorkers: 4}
+	defer w.Close()
+	for i := 0; i < fanoutMinReaders; i++ {
+		r := w.newLiveReader(context.Background(), WithSampleEvery(2))
+		defer r.Close()
+	}
+	full := w.NewReader(0, 1)
+	defer full.Close()
+	// The sampled readers skip write 1, and full takes it.
+	delivered, dropped := w.TryWrite([]byte{1})
+	c.Check(delivered, check.Equals, 1)
+	c.Check(dropped, check.Equals, 0)
+	// They all want write 2, but full's buffer is full.
+	delivered, dropped = w.TryWrite([]byte{2})
+	c.Check(delivered, check.Equals, fanoutMinReaders)
+	c.Check(dropped, check.Equals, 1)
+}
@@ -0,0 +1,77 @@
+package nbtee2
+
+import (
+	"sync"
+	"time"
+)
+
+// Faults injects failures into a reader, so tests can exercise a
+// consumer's gap handling and error recovery without trying to time
+// real races. Attach it with WithFaults, then call its methods from
+// the test while the consumer is running. A Faults should only be
+// attached to one reader. The zero value injects no faults.
+type Faults struct {
+	mtx   sync.Mutex
+	r     *Reader
+	delay time.Duration
+	err   error
+}
+
+// WithFaults attaches f to the reader. See Faults.
+func WithFaults(f *Faults) ReaderOption {
+	return func(r *Reader) {
+		r.faults = f
+		f.mtx.Lock()
+		f.r = r
+		f.mtx.Unlock()
+	}
+}
+
+// Overflow makes the reader drop everything currently in its
+// buffer, as if it had fallen behind by its high watermark. The
+// consumer sees a gap before the next write.
+func (f *Faults) Overflow() {
+	f.mtx.Lock()
+	r := f.r
+	f.mtx.Unlock()
+	if r == nil {
+		return
+	}
+	w := r.lockTee()
+	defer w.mtx.Unlock()
+	for r.discardOldest() != nil {
+	}
+}
+
+// Delay makes the reader wait d before receiving each subsequent
+// write. Zero means no delay.
+func (f *Faults) Delay(d time.Duration) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.delay = d
+}
+
+// Fail makes the next read that needs a new write return err
+// instead. Subsequent reads continue normally.
+func (f *Faults) Fail(err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.err = err
+}
+
+// Apply pending faults before r waits for a write.
+func (f *Faults) before(r *Reader) error {
+	f.mtx.Lock()
+	delay, err := f.delay, f.err
+	f.err = nil
+	f.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	if delay > 0 {
+		if err := r.tee().sleep(r.ctx, delay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
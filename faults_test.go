@@ -0,0 +1,43 @@
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestFaults(c *check.C) {
+	w := &Tee{}
+	f := &Faults{}
+	r := w.newLiveReader(context.Background(), WithFaults(f))
+	defer r.Close()
+
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	f.Overflow()
+	w.Write([]byte{3})
+	msg, err := r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Seq, check.Equals, uint64(3))
+	c.Check(msg.Gap, check.Equals, true)
+
+	errInjected := errors.New("injected")
+	f.Fail(errInjected)
+	w.Write([]byte{4})
+	_, err = r.ReadMessage()
+	c.Check(err, check.Equals, errInjected)
+	msg, err = r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Seq, check.Equals, uint64(4))
+	c.Check(msg.Gap, check.Equals, false)
+
+	f.Delay(20 * time.Millisecond)
+	w.Write([]byte{5})
+	t0 := time.Now()
+	msg, err = r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Seq, check.Equals, uint64(5))
+	c.Check(time.Since(t0) >= 20*time.Millisecond, check.Equals, true)
+}
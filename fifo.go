@@ -0,0 +1,99 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// A FifoSink writes everything sent to a Tee into a named pipe
+// (FIFO), so tools that only know how to read a path can consume the
+// stream.
+//
+// Opening a FIFO for writing blocks until someone opens it for
+// reading, and writing fails with EPIPE after the reader exits. A
+// FifoSink handles both: it waits (without blocking a thread) for a
+// reader, streams to it until it goes away, then waits for the next
+// one. Writes sent while nobody is reading are not delivered.
+type FifoSink struct {
+	Tee *Tee
+
+	// Path of the FIFO. It is created (with mode 0666, less umask)
+	// if it doesn't exist.
+	Path string
+
+	// How often to check for a reader while waiting. Default
+	// 100ms.
+	RetryInterval time.Duration
+
+	// Options for each session's reader.
+	ReaderOptions []ReaderOption
+}
+
+// Run streams to successive readers of the FIFO until the Tee is
+// closed while streaming (returning nil), ctx is cancelled (returning
+// ctx.Err()), or an error other than the reader going away occurs.
+func (fs *FifoSink) Run(ctx context.Context) error {
+	if err := syscall.Mkfifo(fs.Path, 0666); err != nil && !os.IsExist(err) {
+		return &os.PathError{Op: "mkfifo", Path: fs.Path, Err: err}
+	}
+	interval := fs.RetryInterval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	for {
+		f, err := fs.open(ctx, interval)
+		if err != nil {
+			return err
+		}
+		err = fs.stream(ctx, f)
+		if errors.Is(err, syscall.EPIPE) {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+}
+
+// Wait for a reader to open the FIFO, and return it opened for
+// writing.
+func (fs *FifoSink) open(ctx context.Context, interval time.Duration) (*os.File, error) {
+	for {
+		// With O_NONBLOCK, open fails with ENXIO instead of
+		// blocking if there is no reader, and the file uses the
+		// runtime poller, so writes can be interrupted by Close.
+		f, err := os.OpenFile(fs.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			return nil, err
+		}
+		if err := fs.Tee.sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stream from a new reader to f until an error occurs, and close f.
+func (fs *FifoSink) stream(ctx context.Context, f *os.File) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Unblock a pending write if ctx is cancelled.
+		<-ctx.Done()
+		f.Close()
+	}()
+	r := fs.Tee.newLiveReader(ctx, fs.ReaderOptions...)
+	_, err := r.WriteTo(f)
+	return err
+}
@@ -0,0 +1,68 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestFifoSink(c *check.C) {
+	w := &Tee{}
+	path := filepath.Join(c.MkDir(), "fifo")
+	sink := &FifoSink{Tee: w, Path: path, RetryInterval: time.Millisecond}
+	done := make(chan error)
+	go func() { done <- sink.Run(context.Background()) }()
+
+	// Nobody is reading yet.
+	c.Check(w.Stats().Readers, check.HasLen, 0)
+	for {
+		if fi, err := os.Stat(path); err == nil {
+			c.Check(fi.Mode()&os.ModeNamedPipe, check.Not(check.Equals), os.FileMode(0))
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	f, err := os.Open(path)
+	c.Assert(err, check.IsNil)
+	waitReaders(c, w, 1)
+	w.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(f, buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "hello")
+
+	// The reader goes away, and the sink waits for another one.
+	f.Close()
+	w.Write([]byte("lost"))
+	waitReaders(c, w, 0)
+	f, err = os.Open(path)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("again"))
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	rest, err := ioutil.ReadAll(f)
+	c.Check(err, check.IsNil)
+	c.Check(string(rest), check.Equals, "again")
+}
+
+func (s *Suite) TestFifoSinkCancel(c *check.C) {
+	w := &Tee{}
+	path := filepath.Join(c.MkDir(), "fifo")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- (&FifoSink{Tee: w, Path: path}).Run(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+}
@@ -0,0 +1,128 @@
+package nbtee2
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var flvSignature = []byte("FLV\x01")
+
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+// Kinds of FLV tag that are part of the stream header.
+const (
+	flvMetadata = iota
+	flvVideoConfig
+	flvAudioConfig
+	flvHeaderKinds
+)
+
+// An FLVWriter is an io.Writer that sends an FLV stream (e.g., as
+// served by an HTTP-FLV live endpoint) to a Tee one tag at a time, so
+// readers that fall behind drop whole tags.
+//
+// The FLV file header, the most recent onMetaData tag, and the most
+// recent AVC/HEVC and AAC sequence headers are replayed to each new
+// reader before it joins the live stream. If the stream has video,
+// readers skip everything but sequence headers until the next
+// keyframe when they are starting or have just dropped data.
+//
+// Data that doesn't parse as FLV is skipped until the next FLV file
+// header.
+type FLVWriter struct {
+	Tee *Tee
+
+	buf     []byte
+	started bool // got a file header, expecting tags
+	video   bool // file header says there is video
+	nheader int
+	slots   [flvHeaderKinds]int // index+1 of each kind in the header
+}
+
+// Write implements io.Writer. Data is sent to the Tee as each tag is
+// completed. The returned error is always nil.
+func (fw *FLVWriter) Write(p []byte) (int, error) {
+	fw.buf = append(fw.buf, p...)
+	buf := fw.buf
+	for {
+		if !fw.started {
+			i := bytes.Index(buf, flvSignature)
+			if i < 0 {
+				// Keep a possible partial signature.
+				if len(buf) > len(flvSignature)-1 {
+					buf = buf[len(buf)-len(flvSignature)+1:]
+				}
+				break
+			}
+			buf = buf[i:]
+			if len(buf) < 9 {
+				break
+			}
+			size := int(binary.BigEndian.Uint32(buf[5:9])) + 4
+			if size < 13 || size > 1024 {
+				buf = buf[1:]
+				continue
+			}
+			if len(buf) < size {
+				break
+			}
+			fw.started = true
+			fw.video = buf[4]&0x01 != 0
+			fw.nheader = 1
+			fw.slots = [flvHeaderKinds]int{}
+			fw.Tee.sendHeader(append([]byte(nil), buf[:size]...), true)
+			buf = buf[size:]
+			continue
+		}
+		if len(buf) < 11 {
+			break
+		}
+		typ := buf[0] & 0x1f
+		dataSize := int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3])
+		if typ != flvTagAudio && typ != flvTagVideo && typ != flvTagScript {
+			fw.started = false
+			continue
+		}
+		size := 11 + dataSize + 4
+		if len(buf) < size {
+			break
+		}
+		if int(binary.BigEndian.Uint32(buf[size-4:size])) != 11+dataSize {
+			// Previous tag size doesn't match.
+			fw.started = false
+			continue
+		}
+		fw.sendTag(typ, append([]byte(nil), buf[:size]...), dataSize)
+		buf = buf[size:]
+	}
+	fw.buf = append(fw.buf[:0], buf...)
+	return len(p), nil
+}
+
+// Send a tag (including its trailing previous tag size) to the Tee.
+func (fw *FLVWriter) sendTag(typ byte, tag []byte, dataSize int) {
+	data := tag[11 : 11+dataSize]
+	kind := -1
+	switch {
+	case typ == flvTagScript && bytes.HasPrefix(data, []byte("\x02\x00\x0aonMetaData")):
+		kind = flvMetadata
+	case typ == flvTagVideo && len(data) >= 2 && (data[0]&0x0f == 7 || data[0]&0x0f == 12) && data[1] == 0:
+		kind = flvVideoConfig
+	case typ == flvTagAudio && len(data) >= 2 && data[0]>>4 == 10 && data[1] == 0:
+		kind = flvAudioConfig
+	}
+	if kind < 0 {
+		keyframe := typ == flvTagVideo && len(data) > 0 && data[0]>>4 == 1
+		fw.Tee.sendUnit(tag, fw.video && !keyframe)
+	} else if i := fw.slots[kind]; i > 0 {
+		fw.Tee.replaceHeader(i-1, tag)
+	} else {
+		fw.nheader++
+		fw.slots[kind] = fw.nheader
+		fw.Tee.sendHeader(tag, false)
+	}
+}
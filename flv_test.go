@@ -0,0 +1,71 @@
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	check "gopkg.in/check.v1"
+)
+
+// Return an FLV tag, including the trailing previous tag size.
+func flvTag(typ byte, data string) []byte {
+	tag := []byte{typ, 0, 0, byte(len(data)), 0, 0, 0, 0, 0, 0, 0}
+	tag = append(tag, data...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(11+len(data)))
+	return append(tag, size[:]...)
+}
+
+func (s *Suite) TestFLVWriter(c *check.C) {
+	w := &Tee{}
+	fw := &FLVWriter{Tee: w}
+	header := []byte("FLV\x01\x05\x00\x00\x00\x09\x00\x00\x00\x00")
+	meta := flvTag(flvTagScript, "\x02\x00\x0aonMetaData...")
+	avc1 := flvTag(flvTagVideo, "\x17\x00config1")
+	aac := flvTag(flvTagAudio, "\xaf\x00config")
+	key1 := flvTag(flvTagVideo, "\x17\x01key1")
+	inter1 := flvTag(flvTagVideo, "\x27\x01inter1")
+	audio1 := flvTag(flvTagAudio, "\xaf\x01audio1")
+	avc2 := flvTag(flvTagVideo, "\x17\x00config2")
+	key2 := flvTag(flvTagVideo, "\x17\x01key2")
+
+	r1 := w.newLiveReader(context.Background())
+	stream := append([]byte("junk"), header...)
+	for _, tag := range [][]byte{meta, avc1, aac, key1} {
+		stream = append(stream, tag...)
+	}
+	for len(stream) > 0 {
+		n := 7
+		if n > len(stream) {
+			n = len(stream)
+		}
+		fw.Write(stream[:n])
+		stream = stream[n:]
+	}
+	r2 := w.newLiveReader(context.Background())
+	fw.Write(inter1)
+	fw.Write(audio1)
+	fw.Write(avc2)
+	fw.Write(key2)
+	r3 := w.newLiveReader(context.Background())
+	w.Close()
+
+	for _, trial := range []struct {
+		r    *Reader
+		tags [][]byte
+	}{
+		{r1, [][]byte{header, meta, avc1, aac, key1, inter1, audio1, avc2, key2}},
+		{r2, [][]byte{header, meta, avc1, aac, avc2, key2}},
+		{r3, [][]byte{header, meta, avc2, aac}},
+	} {
+		for _, tag := range trial.tags {
+			msg, err := trial.r.ReadMessage()
+			c.Assert(err, check.IsNil)
+			c.Check(msg.Data, check.DeepEquals, tag)
+			c.Check(msg.Gap, check.Equals, false)
+		}
+		_, err := trial.r.ReadMessage()
+		c.Check(err, check.Equals, io.EOF)
+	}
+}
@@ -0,0 +1,95 @@
+package nbtee2
+
+import (
+	"encoding/binary"
+)
+
+// Boxes larger than this are assumed to be garbage.
+const fmp4MaxBox = 64 << 20
+
+// An FMP4Writer is an io.Writer that sends a fragmented MP4 (e.g.,
+// CMAF) stream to a Tee one fragment (moof+mdat, with any preceding
+// styp, sidx, prft, or emsg boxes) at a time, so readers that fall
+// behind drop whole fragments.
+//
+// The init segment (ftyp+moov) is replayed to each new reader before
+// it joins the live stream, so a Media Source Extensions player can
+// attach at any time.
+//
+// Fragments are assumed to start with a sync sample. Data that
+// doesn't look like a sequence of boxes is skipped.
+type FMP4Writer struct {
+	Tee *Tee
+
+	buf      []byte
+	pending  []byte // boxes of the current fragment, before its mdat
+	inHeader bool
+}
+
+// Write implements io.Writer. Data is sent to the Tee as each
+// fragment is completed. The returned error is always nil.
+func (mw *FMP4Writer) Write(p []byte) (int, error) {
+	mw.buf = append(mw.buf, p...)
+	buf := mw.buf
+	for len(buf) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(buf))
+		typ := string(buf[4:8])
+		if size == 1 {
+			if len(buf) < 16 {
+				break
+			}
+			size = binary.BigEndian.Uint64(buf[8:16])
+		}
+		if size < 8 || size > fmp4MaxBox || !fmp4Type(typ) {
+			// Lost sync.
+			mw.pending = nil
+			buf = buf[1:]
+			continue
+		}
+		if uint64(len(buf)) < size {
+			break
+		}
+		mw.sendBox(typ, buf[:size])
+		buf = buf[size:]
+	}
+	mw.buf = append(mw.buf[:0], buf...)
+	return len(p), nil
+}
+
+// Return true if typ looks like a box type.
+func fmp4Type(typ string) bool {
+	for i := 0; i < len(typ); i++ {
+		if typ[i] < 0x20 || typ[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func (mw *FMP4Writer) sendBox(typ string, box []byte) {
+	switch typ {
+	case "ftyp":
+		mw.pending = nil
+		mw.Tee.sendHeader(append([]byte(nil), box...), true)
+		mw.inHeader = true
+	case "moov":
+		mw.pending = nil
+		mw.Tee.sendHeader(append([]byte(nil), box...), !mw.inHeader)
+		mw.inHeader = true
+	case "styp", "sidx", "prft", "emsg", "moof":
+		mw.inHeader = false
+		mw.pending = append(mw.pending, box...)
+	case "mdat":
+		mw.inHeader = false
+		mw.Tee.sendUnit(append(mw.pending, box...), false)
+		mw.pending = nil
+	default:
+		if mw.inHeader {
+			mw.Tee.sendHeader(append([]byte(nil), box...), false)
+		} else if len(mw.pending) > 0 {
+			mw.pending = append(mw.pending, box...)
+		} else {
+			mw.Tee.sendUnit(append([]byte(nil), box...), false)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	check "gopkg.in/check.v1"
+)
+
+func mp4Box(typ, data string) []byte {
+	box := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(box, uint32(8+len(data)))
+	copy(box[4:], typ)
+	return append(box, data...)
+}
+
+func concat(bufs ...[]byte) []byte {
+	var out []byte
+	for _, buf := range bufs {
+		out = append(out, buf...)
+	}
+	return out
+}
+
+func (s *Suite) TestFMP4Writer(c *check.C) {
+	w := &Tee{}
+	mw := &FMP4Writer{Tee: w}
+	ftyp := mp4Box("ftyp", "iso6")
+	moov := mp4Box("moov", "tracks")
+	frag1 := concat(mp4Box("styp", "cmfs"), mp4Box("moof", "1"), mp4Box("mdat", "data1"))
+	// mdat with a 64-bit size
+	mdat2 := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 21, 'd', 'a', 't', 'a', '2'}
+	frag2 := concat(mp4Box("moof", "2"), mdat2)
+	frag3 := concat(mp4Box("moof", "3"), mp4Box("mdat", "data3"))
+
+	f := &Faults{}
+	r1 := w.newLiveReader(context.Background(), WithFaults(f))
+	stream := concat([]byte{0xff, 0xff}, ftyp, moov, frag1)
+	for len(stream) > 0 {
+		n := 5
+		if n > len(stream) {
+			n = len(stream)
+		}
+		mw.Write(stream[:n])
+		stream = stream[n:]
+	}
+	f.Overflow()
+	r2 := w.newLiveReader(context.Background())
+	mw.Write(frag2)
+	mw.Write(frag3)
+	w.Close()
+
+	for _, trial := range []struct {
+		r     *Reader
+		units [][]byte
+		gaps  []bool
+	}{
+		{r1, [][]byte{frag2, frag3}, []bool{true, false}},
+		{r2, [][]byte{ftyp, moov, frag2, frag3}, []bool{false, false, false, false}},
+	} {
+		for i, unit := range trial.units {
+			msg, err := trial.r.ReadMessage()
+			c.Assert(err, check.IsNil)
+			c.Check(msg.Data, check.DeepEquals, unit)
+			c.Check(msg.Gap, check.Equals, trial.gaps[i])
+		}
+		_, err := trial.r.ReadMessage()
+		c.Check(err, check.Equals, io.EOF)
+	}
+}
@@ -0,0 +1,132 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// A FollowOption configures FollowFile.
+type FollowOption func(*follower)
+
+// WithPollInterval sets how often FollowFile checks for new data,
+// truncation, and rotation. The default is 250ms.
+func WithPollInterval(d time.Duration) FollowOption {
+	return func(f *follower) { f.interval = d }
+}
+
+// WithFromStart makes FollowFile send the file's existing content
+// before following it. By default, only data appended after
+// FollowFile starts is sent.
+func WithFromStart() FollowOption {
+	return func(f *follower) { f.fromStart = true }
+}
+
+type follower struct {
+	interval  time.Duration
+	fromStart bool
+	buf       []byte
+}
+
+// FollowFile sends data appended to the file at path to w, like
+// "tail -F", until ctx is cancelled (it then returns ctx.Err()) or an
+// error other than the file not existing occurs. It does not close w.
+//
+// If the file is truncated, FollowFile continues from the start of
+// the file. If the file is replaced (e.g., by log rotation),
+// FollowFile sends whatever remains in the old file, then follows the
+// new one from its start. If the file doesn't exist, FollowFile waits
+// for it to be created.
+func FollowFile(ctx context.Context, path string, w *Tee, opts ...FollowOption) error {
+	fl := &follower{interval: 250 * time.Millisecond}
+	for _, opt := range opts {
+		opt(fl)
+	}
+	fl.buf = make([]byte, 32*1024)
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	fromStart := fl.fromStart
+	for {
+		if f == nil {
+			var err error
+			f, err = os.Open(path)
+			if os.IsNotExist(err) {
+				// Whenever the file appears, it's new.
+				fromStart = true
+			} else if err != nil {
+				return err
+			} else if !fromStart {
+				if _, err := f.Seek(0, io.SeekEnd); err != nil {
+					return err
+				}
+			}
+		}
+		if f != nil {
+			if err := fl.copy(f, w); err != nil {
+				return err
+			}
+			replaced, err := fl.check(f, path)
+			if err != nil {
+				return err
+			}
+			if replaced {
+				// Send anything written to the old file
+				// before it was replaced.
+				if err := fl.copy(f, w); err != nil {
+					return err
+				}
+				f.Close()
+				f = nil
+				fromStart = true
+				continue
+			}
+		}
+		if err := w.sleep(ctx, fl.interval); err != nil {
+			return err
+		}
+	}
+}
+
+// Send everything from the current position to EOF.
+func (fl *follower) copy(f *os.File, w *Tee) error {
+	for {
+		n, err := f.Read(fl.buf)
+		if n > 0 {
+			w.Write(fl.buf[:n])
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Check whether f has been truncated (if so, seek to the start) or
+// path now refers to a different file.
+func (fl *follower) check(f *os.File, path string) (replaced bool, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() < pos {
+		_, err = f.Seek(0, io.SeekStart)
+		return false, err
+	}
+	pfi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return !os.SameFile(fi, pfi), nil
+}
@@ -0,0 +1,71 @@
+package nbtee2
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestFollowFile(c *check.C) {
+	path := filepath.Join(c.MkDir(), "log")
+	c.Assert(ioutil.WriteFile(path, []byte("old\n"), 0666), check.IsNil)
+	w := &Tee{}
+	r := w.NewReader(0, 64)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- FollowFile(ctx, path, w, WithPollInterval(time.Millisecond)) }()
+
+	expect := func(s string) {
+		var got []byte
+		for len(got) < len(s) {
+			msg, err := r.ReadMessage()
+			c.Assert(err, check.IsNil)
+			got = append(got, msg.Data...)
+		}
+		c.Check(string(got), check.Equals, s)
+	}
+	appendFile := func(path, s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		c.Assert(err, check.IsNil)
+		f.Write([]byte(s))
+		f.Close()
+	}
+
+	// Wait for FollowFile to open the file and seek to the end.
+	time.Sleep(20 * time.Millisecond)
+	appendFile(path, "one\n")
+	expect("one\n")
+
+	// Truncate and rewrite
+	c.Assert(ioutil.WriteFile(path, []byte("t\n"), 0666), check.IsNil)
+	expect("t\n")
+
+	// Rotate
+	c.Assert(os.Rename(path, path+".1"), check.IsNil)
+	appendFile(path+".1", "last\n")
+	time.Sleep(20 * time.Millisecond)
+	appendFile(path, "new\n")
+	expect("last\nnew\n")
+
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+}
+
+func (s *Suite) TestFollowFileFromStart(c *check.C) {
+	path := filepath.Join(c.MkDir(), "log")
+	w := &Tee{}
+	r := w.NewReader(0, 64)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go FollowFile(ctx, path, w, WithPollInterval(time.Millisecond), WithFromStart())
+	time.Sleep(10 * time.Millisecond)
+	c.Assert(ioutil.WriteFile(path, []byte("created"), 0666), check.IsNil)
+	msg, err := r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "created")
+}
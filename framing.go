@@ -0,0 +1,192 @@
+package nbtee2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame flags.
+const (
+	frameGap = 1 << iota
+	frameTag
+)
+
+// An Encoder writes Messages to an io.Writer using a simple framing
+// format that preserves write boundaries, tags, and gap markers, so
+// a Tee can be mirrored over any byte stream (see Decoder).
+//
+// Each frame is a flags byte, the data length as a uvarint, the tag
+// (as a uvarint length followed by the tag itself, present only if
+// the tag is non-empty), and the data.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an Encoder that writes to w. Each frame is
+// sent to w in a single Write call.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes one frame.
+func (enc *Encoder) Encode(msg Message) error {
+	var flags byte
+	if msg.Gap {
+		flags |= frameGap
+	}
+	if msg.Tag != "" {
+		flags |= frameTag
+	}
+	buf := append(enc.buf[:0], flags)
+	buf = appendUvarint(buf, uint64(len(msg.Data)))
+	if msg.Tag != "" {
+		buf = appendUvarint(buf, uint64(len(msg.Tag)))
+		buf = append(buf, msg.Tag...)
+	}
+	buf = append(buf, msg.Data...)
+	enc.buf = buf
+	_, err := enc.w.Write(buf)
+	return err
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+// ErrFrameTooLong is returned by Decoder.Decode when a frame exceeds
+// the decoder's MaxLength.
+var ErrFrameTooLong = errors.New("frame too long")
+
+// DefaultMaxFrameLength is the data (or tag) length limit of a
+// Decoder whose MaxLength is zero.
+const DefaultMaxFrameLength = 64 << 20
+
+// A Decoder reads Messages written by an Encoder.
+type Decoder struct {
+	// Maximum data (or tag) length to accept. Zero means
+	// DefaultMaxFrameLength; negative means no limit. Even without
+	// a limit, memory is only allocated as data arrives, so a
+	// corrupt length can't exhaust memory by itself.
+	MaxLength int
+
+	r *bufio.Reader
+}
+
+// NewDecoder returns a Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next frame. Only the Data, Tag, and Gap fields of
+// the returned Message are set. At the end of the stream, Decode
+// returns io.EOF; if the stream ends in the middle of a frame, it
+// returns io.ErrUnexpectedEOF.
+func (dec *Decoder) Decode() (Message, error) {
+	flags, err := dec.r.ReadByte()
+	if err != nil {
+		return Message{}, err
+	}
+	if flags&^(frameGap|frameTag) != 0 {
+		return Message{}, fmt.Errorf("invalid frame flags %#x", flags)
+	}
+	msg := Message{Gap: flags&frameGap != 0}
+	size, err := dec.readLength()
+	if err != nil {
+		return Message{}, err
+	}
+	if flags&frameTag != 0 {
+		tag, err := dec.readBytes()
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Tag = string(tag)
+	}
+	msg.Data, err = dec.readN(size)
+	if err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+func (dec *Decoder) readLength() (int, error) {
+	x, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	max := dec.MaxLength
+	if max == 0 {
+		max = DefaultMaxFrameLength
+	}
+	if (max > 0 && x > uint64(max)) || x > uint64(int(^uint(0)>>1)) {
+		return 0, ErrFrameTooLong
+	}
+	return int(x), nil
+}
+
+func (dec *Decoder) readBytes() ([]byte, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	return dec.readN(size)
+}
+
+// Read size bytes. Large reads are buffered in chunks, so memory is
+// only allocated for data that actually arrives.
+func (dec *Decoder) readN(size int) ([]byte, error) {
+	const chunk = 64 << 10
+	if size <= chunk {
+		buf := make([]byte, size)
+		_, err := io.ReadFull(dec.r, buf)
+		return buf, noEOF(err)
+	}
+	buf := make([]byte, 0, chunk)
+	for len(buf) < size {
+		n := size - len(buf)
+		if n > chunk {
+			n = chunk
+		}
+		if cap(buf)-len(buf) < n {
+			grown := 2 * cap(buf)
+			if grown > size {
+				grown = size
+			}
+			buf = append(make([]byte, 0, grown), buf...)
+		}
+		nn, err := io.ReadFull(dec.r, buf[len(buf):len(buf)+n])
+		buf = buf[:len(buf)+nn]
+		if err != nil {
+			return nil, noEOF(err)
+		}
+	}
+	return buf, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Mirror decodes frames from r and sends them to w with
+// WriteMessage, until r reaches EOF (in which case it returns nil)
+// or an error occurs. It does not close w.
+func Mirror(w *Tee, r io.Reader) error {
+	dec := NewDecoder(r)
+	for {
+		msg, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		w.WriteMessage(msg)
+	}
+}
@@ -0,0 +1,104 @@
+package nbtee2
+
+import (
+	"bytes"
+	"io"
+	"net"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestFramingRoundTrip(c *check.C) {
+	msgs := []Message{
+		{Data: []byte("foo")},
+		{Data: []byte{}, Tag: "t"},
+		{Data: bytes.Repeat([]byte{'x'}, 300), Gap: true},
+		{Data: []byte("bar"), Tag: "source2", Gap: true},
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, msg := range msgs {
+		c.Check(enc.Encode(msg), check.IsNil)
+	}
+	encoded := buf.Bytes()
+	dec := NewDecoder(bytes.NewReader(encoded))
+	for _, expect := range msgs {
+		msg, err := dec.Decode()
+		c.Check(err, check.IsNil)
+		c.Check(msg, check.DeepEquals, expect)
+	}
+	_, err := dec.Decode()
+	c.Check(err, check.Equals, io.EOF)
+
+	_, err = NewDecoder(bytes.NewReader(encoded[:4])).Decode()
+	c.Check(err, check.Equals, io.ErrUnexpectedEOF)
+
+	dec = NewDecoder(bytes.NewReader(encoded))
+	dec.MaxLength = 100
+	dec.Decode()
+	dec.Decode()
+	_, err = dec.Decode()
+	c.Check(err, check.Equals, ErrFrameTooLong)
+
+	_, err = NewDecoder(bytes.NewReader([]byte{0x80, 0})).Decode()
+	c.Check(err, check.ErrorMatches, "invalid frame flags.*")
+}
+
+func (s *Suite) TestDecoderCorruptLength(c *check.C) {
+	corrupt := []byte{0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 1, 2, 3}
+	_, err := NewDecoder(bytes.NewReader(corrupt)).Decode()
+	c.Check(err, check.Equals, ErrFrameTooLong)
+
+	// With no limit, the stream ends long before the claimed
+	// length.
+	dec := NewDecoder(bytes.NewReader(corrupt))
+	dec.MaxLength = -1
+	_, err = dec.Decode()
+	c.Check(err, check.Equals, io.ErrUnexpectedEOF)
+
+	// Frames larger than the read chunk size still work.
+	var buf bytes.Buffer
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	NewEncoder(&buf).Encode(Message{Data: data})
+	msg, err := NewDecoder(&buf).Decode()
+	c.Check(err, check.IsNil)
+	c.Check(bytes.Equal(msg.Data, data), check.Equals, true)
+}
+
+func (s *Suite) TestMirror(c *check.C) {
+	a, b := &Tee{}, &Tee{}
+	ra := a.NewReader(0, 16)
+	rb := b.NewReader(0, 16)
+	pr, pw := net.Pipe()
+	go func() {
+		enc := NewEncoder(pw)
+		for {
+			msg, err := ra.ReadMessage()
+			if err != nil {
+				pw.Close()
+				return
+			}
+			enc.Encode(msg)
+		}
+	}()
+	mirrored := make(chan error)
+	go func() { mirrored <- Mirror(b, pr) }()
+
+	a.Writer("x").Write([]byte("foo"))
+	a.WriteMessage(Message{Data: []byte("bar"), Gap: true})
+	a.Close()
+	c.Check(<-mirrored, check.IsNil)
+	b.Close()
+
+	msg, err := rb.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "foo")
+	c.Check(msg.Tag, check.Equals, "x")
+	c.Check(msg.Gap, check.Equals, false)
+	msg, err = rb.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "bar")
+	c.Check(msg.Gap, check.Equals, true)
+	_, err = rb.ReadMessage()
+	c.Check(err, check.Equals, io.EOF)
+}
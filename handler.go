@@ -0,0 +1,259 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StreamHandler is an http.Handler that streams everything sent to
+// a Tee to each client, as a single long response body.
+type StreamHandler struct {
+	Tee *Tee
+
+	// Content-Type of responses. Default "application/octet-stream".
+	ContentType string
+
+	// PartContentType, if not empty, makes the response a
+	// multipart/x-mixed-replace stream with each write to the Tee
+	// as a separate part, having the given Content-Type (e.g.,
+	// "image/jpeg" for MJPEG) and a Content-Length header. In this
+	// mode, ContentType is ignored.
+	PartContentType string
+
+	// Options for each client's reader.
+	ReaderOptions []ReaderOption
+
+	// By default, the response is flushed after each write to the
+	// Tee. For high rates of small writes, this is expensive. If
+	// FlushBytes > 0, the response is flushed once at least
+	// FlushBytes have been written since the last flush. If
+	// FlushInterval > 0, unflushed data is flushed at most
+	// FlushInterval after it is written. If both are set, whichever
+	// comes first applies. (With only FlushBytes, data can stay
+	// unflushed indefinitely when writes stop.)
+	FlushBytes    int
+	FlushInterval time.Duration
+
+	// Compression, if not empty, lists content codings to use for
+	// clients that accept them (per the request's Accept-Encoding
+	// header), in order of preference. Each flush also flushes the
+	// compressor, so compressed responses still stream.
+	Compression []Compression
+
+	// Authorize, if not nil, is called before streaming to each
+	// client. If it returns an error, the client gets an error
+	// response instead of the stream. The response status is 403
+	// unless the error has an HTTPStatus() int method, in which
+	// case that is used.
+	Authorize func(*http.Request) error
+
+	// Disconnected, if not nil, is called with each client's
+	// session stats after it disconnects.
+	Disconnected func(ConnStats)
+
+	sessions sessions
+}
+
+// Shutdown stops streaming new writes to connected clients, lets
+// each one finish sending what its reader has buffered, and waits
+// for their responses to end. If ctx is done first, the remaining
+// responses are cut off and Shutdown returns ctx.Err(). Requests
+// that arrive after Shutdown get a 503 response.
+//
+// Call this before http.Server.Shutdown, which waits for handlers to
+// return but doesn't tell them to stop.
+func (h *StreamHandler) Shutdown(ctx context.Context) error {
+	return h.sessions.drain(ctx)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !authorize(w, req, h.Authorize) {
+		return
+	}
+	start := h.Tee.now()
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	r := h.Tee.newLiveReader(ctx, h.ReaderOptions...)
+	defer r.Close()
+	if !h.sessions.add(r, cancel) {
+		http.Error(w, ErrShutdown.Error(), http.StatusServiceUnavailable)
+		if h.Disconnected != nil {
+			h.Disconnected(connStats(req.RemoteAddr, start, r, 0, ErrShutdown))
+		}
+		return
+	}
+	defer h.sessions.done(r)
+	fw := &flushWriter{w: w, bytes: h.FlushBytes, interval: h.FlushInterval, clock: h.Tee.clock}
+	fw.f, _ = w.(http.Flusher)
+	if len(h.Compression) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if comp := negotiateCompression(req, h.Compression); comp != nil {
+		zw, err := comp.NewWriter(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Encoding", comp.Name)
+		fw.w, fw.zw = zw, zw
+	}
+	var mw *multipart.Writer
+	ctype := h.ContentType
+	if h.PartContentType != "" {
+		mw = multipart.NewWriter(fw)
+		ctype = "multipart/x-mixed-replace; boundary=" + mw.Boundary()
+	} else if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fw.flush()
+	var n int64
+	var err error
+	if mw != nil {
+		n, err = writeParts(r, mw, h.PartContentType)
+	} else {
+		n, err = r.WriteTo(fw)
+	}
+	fw.stop()
+	if h.Disconnected != nil {
+		h.Disconnected(connStats(req.RemoteAddr, start, r, n, err))
+	}
+}
+
+// Send each write from r as a part of a multipart stream. Return the
+// number of data bytes sent (not counting part headers and
+// boundaries) and the error that ended the stream.
+func writeParts(r *Reader, mw *multipart.Writer, ctype string) (n int64, err error) {
+	hdr := textproto.MIMEHeader{"Content-Type": {ctype}}
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			// End of stream: send the closing boundary.
+			mw.Close()
+		}
+		if err != nil {
+			return n, err
+		}
+		hdr.Set("Content-Length", strconv.Itoa(len(msg.Data)))
+		part, err := mw.CreatePart(hdr)
+		if err != nil {
+			return n, err
+		}
+		nn, err := part.Write(msg.Data)
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// If authz returns an error for req, send an error response and
+// return false.
+func authorize(w http.ResponseWriter, req *http.Request, authz func(*http.Request) error) bool {
+	if authz == nil {
+		return true
+	}
+	err := authz(req)
+	if err == nil {
+		return true
+	}
+	status := http.StatusForbidden
+	if err, ok := err.(interface{ HTTPStatus() int }); ok {
+		status = err.HTTPStatus()
+	}
+	http.Error(w, err.Error(), status)
+	return false
+}
+
+// flushWriter flushes the response according to the handler's
+// flush settings.
+type flushWriter struct {
+	w        io.Writer // response, or zw
+	zw       CompressWriter
+	f        http.Flusher
+	bytes    int
+	interval time.Duration
+	clock    Clock
+
+	pending   int // bytes written since last flush
+	timer     Timer
+	stopTimer chan struct{} // closed when timer is stopped
+	done      bool
+	mtx       sync.Mutex
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mtx.Lock()
+	defer fw.mtx.Unlock()
+	n, err := fw.w.Write(p)
+	fw.pending += n
+	if fw.bytes <= 0 && fw.interval <= 0 {
+		fw.flushLocked()
+	} else if fw.bytes > 0 && fw.pending >= fw.bytes {
+		fw.flushLocked()
+	} else if fw.interval > 0 && fw.timer == nil && fw.pending > 0 {
+		fw.timer = clockTimer(fw.clock, fw.interval)
+		fw.stopTimer = make(chan struct{})
+		go fw.flushAfter(fw.timer, fw.stopTimer)
+	}
+	return n, err
+}
+
+// Flush when t fires, unless stop is closed first.
+func (fw *flushWriter) flushAfter(t Timer, stop <-chan struct{}) {
+	select {
+	case <-t.C():
+		fw.flush()
+	case <-stop:
+	}
+}
+
+func (fw *flushWriter) flush() {
+	fw.mtx.Lock()
+	defer fw.mtx.Unlock()
+	fw.flushLocked()
+}
+
+func (fw *flushWriter) flushLocked() {
+	if fw.timer != nil {
+		fw.timer.Stop()
+		close(fw.stopTimer)
+		fw.timer, fw.stopTimer = nil, nil
+	}
+	if !fw.done {
+		if fw.zw != nil {
+			fw.zw.Flush()
+		}
+		if fw.f != nil {
+			fw.f.Flush()
+		}
+	}
+	fw.pending = 0
+}
+
+// Stop flushing. The handler must call this before returning.
+func (fw *flushWriter) stop() {
+	fw.mtx.Lock()
+	defer fw.mtx.Unlock()
+	if fw.zw != nil {
+		// Finish the compressed stream, and flush its trailer.
+		fw.zw.Close()
+		fw.zw = nil
+		fw.pending++
+	}
+	if fw.pending > 0 {
+		fw.flushLocked()
+	} else if fw.timer != nil {
+		fw.timer.Stop()
+	}
+	fw.done = true
+}
@@ -0,0 +1,190 @@
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+type statusError int
+
+func (e statusError) Error() string   { return http.StatusText(int(e)) }
+func (e statusError) HTTPStatus() int { return int(e) }
+
+func (s *Suite) TestStreamHandler(c *check.C) {
+	w := &Tee{}
+	disconnected := make(chan ConnStats, 1)
+	srv := httptest.NewServer(&StreamHandler{
+		Tee:          w,
+		ContentType:  "text/plain",
+		Disconnected: func(cs ConnStats) { disconnected <- cs },
+	})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	c.Check(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Check(resp.Header.Get("Content-Type"), check.Equals, "text/plain")
+	waitReaders(c, w, 1)
+
+	w.Write([]byte("foo"))
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(resp.Body, buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+	w.Write([]byte("bar"))
+	w.Close()
+	rest, err := ioutil.ReadAll(resp.Body)
+	c.Check(err, check.IsNil)
+	c.Check(string(rest), check.Equals, "bar")
+	cs := <-disconnected
+	c.Check(cs.Bytes, check.Equals, int64(6))
+	c.Check(cs.Err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestStreamHandlerAuthorize(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	h := &StreamHandler{Tee: w, Authorize: func(req *http.Request) error {
+		switch req.Header.Get("Authorization") {
+		case "":
+			return statusError(http.StatusUnauthorized)
+		case "ok":
+			return nil
+		default:
+			return errors.New("go away")
+		}
+	}}
+	for auth, status := range map[string]int{
+		"":    http.StatusUnauthorized,
+		"bad": http.StatusForbidden,
+		"ok":  http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", auth)
+		resp := httptest.NewRecorder()
+		if auth == "ok" {
+			// Cancel the request so the stream ends.
+			ctx, cancel := context.WithCancel(req.Context())
+			cancel()
+			req = req.WithContext(ctx)
+		}
+		h.ServeHTTP(resp, req)
+		c.Check(resp.Code, check.Equals, status, check.Commentf("auth %q", auth))
+	}
+}
+
+// A ResponseWriter that records the length of the body at each flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed []int
+	mtx     sync.Mutex
+}
+
+func (fr *flushRecorder) Write(p []byte) (int, error) {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	return fr.ResponseRecorder.Write(p)
+}
+
+func (fr *flushRecorder) Flush() {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	fr.flushed = append(fr.flushed, fr.Body.Len())
+}
+
+func (fr *flushRecorder) flushes() []int {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	return append([]int(nil), fr.flushed...)
+}
+
+func (s *Suite) TestStreamHandlerFlush(c *check.C) {
+	for _, trial := range []struct {
+		bytes    int
+		interval time.Duration
+		expect   []int
+	}{
+		{0, 0, []int{0, 4, 8, 12, 16, 20}},
+		{10, 0, []int{0, 12, 20}},
+		{0, time.Hour, []int{0, 20}},
+	} {
+		w := &Tee{}
+		h := &StreamHandler{Tee: w, FlushBytes: trial.bytes, FlushInterval: trial.interval}
+		fr := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		done := make(chan struct{})
+		go func() {
+			h.ServeHTTP(fr, httptest.NewRequest("GET", "/", nil))
+			close(done)
+		}()
+		waitReaders(c, w, 1)
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("abcd"))
+			// Let the handler keep up
+			for len(w.Stats().Readers) > 0 && w.Stats().Readers[0].Buffered > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		w.Close()
+		<-done
+		c.Check(fr.flushes(), check.DeepEquals, trial.expect, check.Commentf("%+v", trial))
+	}
+}
+
+func (s *Suite) TestStreamHandlerFlushInterval(c *check.C) {
+	w := &Tee{}
+	h := &StreamHandler{Tee: w, FlushBytes: 1000, FlushInterval: time.Millisecond}
+	fr := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(fr, httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	waitReaders(c, w, 1)
+	w.Write([]byte("abcd"))
+	for len(fr.flushes()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Check(fr.flushes(), check.DeepEquals, []int{0, 4})
+	w.Close()
+	<-done
+}
+
+func (s *Suite) TestStreamHandlerMultipart(c *check.C) {
+	w := &Tee{}
+	srv := httptest.NewServer(&StreamHandler{Tee: w, PartContentType: "image/jpeg"})
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	mtype, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	c.Assert(err, check.IsNil)
+	c.Check(mtype, check.Equals, "multipart/x-mixed-replace")
+	waitReaders(c, w, 1)
+	w.Write([]byte("abc"))
+	w.Write([]byte("de"))
+	w.Close()
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for _, want := range []string{"abc", "de"} {
+		part, err := mr.NextPart()
+		c.Assert(err, check.IsNil)
+		c.Check(part.Header.Get("Content-Type"), check.Equals, "image/jpeg")
+		c.Check(part.Header.Get("Content-Length"), check.Equals, strconv.Itoa(len(want)))
+		buf, err := ioutil.ReadAll(part)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, want)
+	}
+	_, err = mr.NextPart()
+	c.Check(err, check.Equals, io.EOF)
+}
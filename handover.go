@@ -0,0 +1,73 @@
+package nbtee2
+
+import "sync"
+
+// Serializes MoveTo calls, so two of them can't lock the same pair of
+// Tees in opposite orders.
+var handoverMtx sync.Mutex
+
+// MoveTo detaches r from its Tee and attaches it to dst, in a single
+// step that neither Tee's writers can interleave with, for failing
+// over from one source to a standby without disturbing consumers.
+//
+// The consumer keeps the same Reader and options, and receives
+// everything already in r's buffer before any writes sent to dst.
+// The first write from dst has Gap set, since the two streams are
+// not continuous. If dst is a container stream (see OggWriter), its
+// header is delivered before its first write. The reader gets a new
+// ID, unique within dst.
+//
+// MoveTo returns false, without doing anything, if r has been
+// closed or detached.
+func (r *Reader) MoveTo(dst *Tee) bool {
+	handoverMtx.Lock()
+	defer handoverMtx.Unlock()
+	src := r.lockTee()
+	defer src.mtx.Unlock()
+	if !src.readers[r] {
+		return false
+	}
+	if src == dst {
+		return true
+	}
+	dst.mtx.Lock()
+	defer dst.mtx.Unlock()
+	delete(src.readers, r)
+	src.active = src.now()
+
+	// The consumer finishes reading the old channel, then sees it
+	// closed and switches to the new one (see switchChannel).
+	r.replaceChannel(r.highwater, pendingChannel{moved: true, writes: uint64(dst.writes)})
+	for _, m := range dst.header {
+		r.offer(m)
+	}
+
+	r.wv.Store(dst)
+	if dst.readers == nil {
+		dst.readers = make(map[*Reader]bool, 1)
+	}
+	dst.nextID++
+	r.id = dst.nextID
+	dst.readers[r] = true
+	dst.active = dst.now()
+	return true
+}
+
+// Return the Tee r is attached to (or was last attached to).
+func (r *Reader) tee() *Tee {
+	return r.wv.Load().(*Tee)
+}
+
+// Lock and return the Tee r is attached to. The caller must unlock
+// w.mtx.
+func (r *Reader) lockTee() *Tee {
+	for {
+		w := r.tee()
+		w.mtx.Lock()
+		if r.tee() == w {
+			return w
+		}
+		// Moved while we were waiting.
+		w.mtx.Unlock()
+	}
+}
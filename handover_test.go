@@ -0,0 +1,94 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestMoveTo(c *check.C) {
+	src, dst := &Tee{}, &Tee{}
+	dst.newLiveReader(context.Background())
+	r := src.newLiveReader(context.Background())
+	src.Write([]byte{1})
+	src.Write([]byte{2})
+	dst.Write([]byte{10})
+	c.Check(r.MoveTo(dst), check.Equals, true)
+	src.Write([]byte{3})
+	dst.Write([]byte{11})
+	dst.Write([]byte{12})
+	c.Check(src.Stats().Readers, check.HasLen, 0)
+	c.Check(dst.Stats().Readers, check.HasLen, 2)
+	c.Check(r.id, check.Equals, uint64(2))
+	dst.Close()
+
+	for _, expect := range []struct {
+		data byte
+		gap  bool
+	}{{1, false}, {2, false}, {11, true}, {12, false}} {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Data, check.DeepEquals, []byte{expect.data})
+		c.Check(msg.Gap, check.Equals, expect.gap)
+	}
+	_, err := r.ReadMessage()
+	c.Check(err, check.Equals, io.EOF)
+	c.Check(r.MoveTo(src), check.Equals, false)
+}
+
+func (s *Suite) TestMoveToThenSetHighWater(c *check.C) {
+	src, dst := &Tee{}, &Tee{}
+	r := src.newLiveReader(context.Background())
+	src.Write([]byte{1})
+	dst.Write([]byte{10})
+	c.Check(r.MoveTo(dst), check.Equals, true)
+	dst.Write([]byte{11})
+	r.SetHighWater(8)
+	dst.Write([]byte{12})
+	dst.Close()
+
+	for _, expect := range []struct {
+		data byte
+		gap  bool
+	}{{1, false}, {11, true}, {12, false}} {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Data, check.DeepEquals, []byte{expect.data})
+		c.Check(msg.Gap, check.Equals, expect.gap)
+	}
+	_, err := r.ReadMessage()
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestMoveToWhileReading(c *check.C) {
+	src, dst := &Tee{}, &Tee{}
+	r := src.newLiveReader(context.Background())
+	done := make(chan []byte)
+	go func() {
+		var got []byte
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				done <- got
+				return
+			}
+			got = append(got, msg.Data...)
+		}
+	}()
+	src.Write([]byte{1})
+	for i := byte(2); i < 100; i++ {
+		if i == 50 {
+			r.MoveTo(dst)
+		}
+		src.Write([]byte{i})
+		dst.Write([]byte{i})
+	}
+	dst.Close()
+	got := <-done
+	c.Check(len(got) > 0, check.Equals, true)
+	c.Check(got[len(got)-1], check.Equals, byte(99))
+	for i := 1; i < len(got); i++ {
+		c.Check(got[i] > got[i-1], check.Equals, true)
+	}
+}
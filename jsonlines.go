@@ -0,0 +1,75 @@
+package nbtee2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONLine is the JSON representation of a message produced by
+// NewJSONLinesReader.
+type JSONLine struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Tag  string    `json:"tag,omitempty"`
+	Gap  bool      `json:"gap,omitempty"`
+
+	// The message data, base64-encoded. Omitted in text mode.
+	Data []byte `json:"data,omitempty"`
+
+	// The message data as a string, in text mode. Invalid UTF-8
+	// is replaced with U+FFFD, so text mode is only suitable for
+	// streams of text.
+	Text *string `json:"text,omitempty"`
+}
+
+func newJSONLine(msg Message, text bool) JSONLine {
+	line := JSONLine{Seq: msg.Seq, Time: msg.Time, Tag: msg.Tag, Gap: msg.Gap}
+	if text {
+		s := string(msg.Data)
+		line.Text = &s
+	} else {
+		line.Data = msg.Data
+	}
+	return line
+}
+
+// NewJSONLinesReader returns an io.ReadCloser that reads messages
+// from r and returns each one as a line of JSON (see JSONLine), for
+// consumers that want a self-describing text stream. If text is true,
+// message data is sent as a string instead of base64.
+//
+// Closing the returned reader closes r.
+func NewJSONLinesReader(r *Reader, text bool) io.ReadCloser {
+	return &jsonLinesReader{r: r, text: text}
+}
+
+type jsonLinesReader struct {
+	r    *Reader
+	text bool
+	buf  bytes.Buffer
+	enc  *json.Encoder
+}
+
+func (jr *jsonLinesReader) Read(p []byte) (int, error) {
+	if jr.buf.Len() == 0 {
+		msg, err := jr.r.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		line := newJSONLine(msg, jr.text)
+		if jr.enc == nil {
+			jr.enc = json.NewEncoder(&jr.buf)
+			jr.enc.SetEscapeHTML(false)
+		}
+		if err := jr.enc.Encode(line); err != nil {
+			return 0, err
+		}
+	}
+	return jr.buf.Read(p)
+}
+
+func (jr *jsonLinesReader) Close() error {
+	return jr.r.Close()
+}
@@ -0,0 +1,46 @@
+package nbtee2
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestJSONLines(c *check.C) {
+	for _, text := range []bool{false, true} {
+		w := &Tee{}
+		r := NewJSONLinesReader(w.NewReader(0, 16), text)
+		w.Write([]byte("foo<"))
+		w.Writer("t").Write([]byte{})
+		w.WriteMessage(Message{Data: []byte("bar"), Gap: true})
+		w.Close()
+		buf, err := ioutil.ReadAll(r)
+		c.Check(err, check.IsNil)
+		c.Check(r.Close(), check.IsNil)
+
+		var lines []JSONLine
+		scanner := bufio.NewScanner(strings.NewReader(string(buf)))
+		for scanner.Scan() {
+			var line JSONLine
+			c.Check(json.Unmarshal(scanner.Bytes(), &line), check.IsNil)
+			c.Check(line.Time.IsZero(), check.Equals, false)
+			lines = append(lines, line)
+		}
+		c.Assert(lines, check.HasLen, 3)
+		c.Check(lines[0].Seq, check.Equals, uint64(1))
+		c.Check(lines[1].Tag, check.Equals, "t")
+		c.Check(lines[2].Gap, check.Equals, true)
+		if text {
+			c.Check(*lines[0].Text, check.Equals, "foo<")
+			c.Check(*lines[1].Text, check.Equals, "")
+			c.Check(strings.Contains(string(buf), `"text":"foo<"`), check.Equals, true)
+		} else {
+			c.Check(string(lines[0].Data), check.Equals, "foo<")
+			c.Check(lines[0].Text, check.IsNil)
+			c.Check(strings.Contains(string(buf), `"data":"Zm9vPA=="`), check.Equals, true)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/tomclegg/nbtee2/nbteesrt
+
+go 1.25.0
+
+require (
+	github.com/datarhei/gosrt v0.11.0
+	github.com/tomclegg/nbtee2 v0.0.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+)
+
+require (
+	github.com/benburkert/openpgp v0.0.0-20160410205803-c2471f86866c // indirect
+	github.com/kr/pretty v0.2.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	golang.org/x/net v0.53.0 // indirect
+	golang.org/x/sys v0.44.0 // indirect
+)
+
+replace github.com/tomclegg/nbtee2 => ../
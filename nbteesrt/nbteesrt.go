@@ -0,0 +1,125 @@
+// Package nbteesrt connects an nbtee2.Tee to SRT, so it can feed
+// broadcast ingest endpoints that don't speak HTTP, in either of
+// SRT's connection modes:
+//
+// In caller mode, the Tee connects to the endpoint: use Dialer as the
+// Dial function of an nbtee2.PushSink, which reconnects after
+// failures.
+//
+// In listener mode, the endpoint connects to the Tee: pass a Listener
+// to nbtee2.Server.Serve, or its accepted connections to
+// nbtee2.ConnSet.Add.
+//
+// It is a separate module so that nbtee2 itself doesn't depend on an
+// SRT implementation.
+package nbteesrt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// ErrWriteFailed is returned by writes to a connection whose send
+// queue is full, or that has been closed. The SRT library reports
+// both as io.EOF, which would otherwise look like the end of the
+// stream.
+var ErrWriteFailed = errors.New("nbteesrt: connection closed or send queue full")
+
+// Dialer returns a function that connects to the SRT listener at
+// address, for use as nbtee2.PushSink.Dial. Start config from
+// srt.DefaultConfig (live mode, 1316-byte payloads), and set
+// StreamId if the endpoint requires it.
+//
+// The SRT library splits each write into payload-sized packets, so
+// PushSink.MaxWrite isn't needed; for MPEG-TS, make each write a
+// whole number of 188-byte packets so none straddle two SRT packets.
+func Dialer(address string, config srt.Config) func(ctx context.Context) (net.Conn, error) {
+	return func(ctx context.Context) (net.Conn, error) {
+		type result struct {
+			conn srt.Conn
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			c, err := srt.Dial("srt", address, config)
+			done <- result{c, err}
+		}()
+		select {
+		case res := <-done:
+			if res.err != nil {
+				return nil, res.err
+			}
+			return conn{res.conn}, nil
+		case <-ctx.Done():
+			go func() {
+				// Don't leak a connection that succeeds
+				// after all.
+				if res := <-done; res.err == nil {
+					res.conn.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// A Listener is a net.Listener that accepts connections from SRT
+// callers on an srt.Listener.
+type Listener struct {
+	srt.Listener
+
+	// Authorize, if not nil, is called with each connection
+	// request (e.g., to check its StreamId). If it returns an
+	// error, the request is rejected.
+	Authorize func(req srt.ConnRequest) error
+}
+
+// Accept waits for the next authorized connection. After Close, it
+// returns net.ErrClosed.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		req, err := l.Listener.Accept2()
+		if errors.Is(err, srt.ErrListenerClosed) {
+			return nil, net.ErrClosed
+		} else if err != nil {
+			return nil, err
+		}
+		if l.Authorize != nil {
+			if err := l.Authorize(req); err != nil {
+				req.Reject(srt.REJX_FORBIDDEN)
+				continue
+			}
+		}
+		c, err := req.Accept()
+		if err != nil {
+			// The handshake failed; wait for the next
+			// caller.
+			continue
+		}
+		return conn{c}, nil
+	}
+}
+
+// Close stops accepting connections. The SRT library also closes the
+// connections already accepted.
+func (l *Listener) Close() error {
+	l.Listener.Close()
+	return nil
+}
+
+// A conn is an SRT connection as a net.Conn.
+type conn struct {
+	srt.Conn
+}
+
+func (c conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err == io.EOF {
+		err = ErrWriteFailed
+	}
+	return n, err
+}
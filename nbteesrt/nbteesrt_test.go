@@ -0,0 +1,114 @@
+package nbteesrt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+	"github.com/tomclegg/nbtee2"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type Suite struct{}
+
+var _ = check.Suite(&Suite{})
+
+// Wait until w has n readers.
+func waitReaders(c *check.C, w *nbtee2.Tee, n int) {
+	for deadline := time.Now().Add(10 * time.Second); len(w.Stats().Readers) != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for %d readers", n)
+		}
+	}
+}
+
+func (s *Suite) TestListener(c *check.C) {
+	ln, err := srt.Listen("srt", "127.0.0.1:0", srt.DefaultConfig())
+	c.Assert(err, check.IsNil)
+	l := &Listener{Listener: ln, Authorize: func(req srt.ConnRequest) error {
+		if req.StreamId() != "ok" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}}
+	w := &nbtee2.Tee{}
+	defer w.Close()
+	served := make(chan error, 1)
+	go func() { served <- (&nbtee2.Server{Tee: w}).Serve(l) }()
+
+	config := srt.DefaultConfig()
+	config.StreamId = "nope"
+	_, err = srt.Dial("srt", ln.Addr().String(), config)
+	c.Check(err, check.NotNil)
+
+	config.StreamId = "ok"
+	client, err := srt.Dial("srt", ln.Addr().String(), config)
+	c.Assert(err, check.IsNil)
+	defer client.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	buf := make([]byte, 1500)
+	n, err := client.Read(buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf[:n]), check.Equals, "foo")
+
+	l.Close()
+	c.Check(errors.Is(<-served, net.ErrClosed), check.Equals, true)
+}
+
+func (s *Suite) TestDialer(c *check.C) {
+	ln, err := srt.Listen("srt", "127.0.0.1:0", srt.DefaultConfig())
+	c.Assert(err, check.IsNil)
+	defer ln.Close()
+	accepted := make(chan srt.Conn, 1)
+	go func() {
+		req, err := ln.Accept2()
+		c.Check(err, check.IsNil)
+		conn, err := req.Accept()
+		c.Check(err, check.IsNil)
+		accepted <- conn
+	}()
+
+	w := &nbtee2.Tee{}
+	ps := &nbtee2.PushSink{Tee: w, Dial: Dialer(ln.Addr().String(), srt.DefaultConfig())}
+	done := make(chan error, 1)
+	go func() { done <- ps.Run(context.Background()) }()
+	conn := <-accepted
+	c.Assert(conn, check.NotNil)
+	defer conn.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf[:n]), check.Equals, "foo")
+	w.Close()
+	c.Check(<-done, check.IsNil)
+}
+
+func (s *Suite) TestDialerCancel(c *check.C) {
+	// Nothing is listening, so the handshake can't finish.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer pc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = Dialer(pc.LocalAddr().String(), srt.DefaultConfig())(ctx)
+	c.Check(err, check.Equals, context.DeadlineExceeded)
+}
+
+// An eofConn fails writes the way a closed srt.Conn does.
+type eofConn struct{ srt.Conn }
+
+func (eofConn) Write([]byte) (int, error) { return 0, io.EOF }
+
+func (s *Suite) TestWriteFailed(c *check.C) {
+	_, err := conn{eofConn{}}.Write([]byte("foo"))
+	c.Check(err, check.Equals, ErrWriteFailed)
+}
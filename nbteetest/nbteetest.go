@@ -0,0 +1,185 @@
+// Package nbteetest provides utilities for testing code that
+// produces to or consumes from an nbtee2.Tee: a producer whose
+// messages can be verified after delivery, a consumer that only reads
+// when told to, and a destination writer that blocks or fails on
+// command.
+package nbteetest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tomclegg/nbtee2"
+)
+
+// DefaultSize is the size of each message written by a Producer,
+// unless its Size field is set.
+const DefaultSize = 16
+
+// Numbered returns message number i, with the given size (at least
+// 8 bytes). The message starts with i as a big-endian uint64, and the
+// rest is filled with bytes derived from i, so truncation and
+// corruption can be detected.
+func Numbered(i, size int) []byte {
+	if size < 8 {
+		size = 8
+	}
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint64(buf, uint64(i))
+	for j := 8; j < size; j++ {
+		buf[j] = byte(i + j)
+	}
+	return buf
+}
+
+// Parse returns the number of a message made by Numbered, or an
+// error if it isn't a valid message of the given size.
+func Parse(buf []byte, size int) (int, error) {
+	if size < 8 {
+		size = 8
+	}
+	if len(buf) != size {
+		return 0, fmt.Errorf("message has %d bytes, expected %d", len(buf), size)
+	}
+	i := int(binary.BigEndian.Uint64(buf))
+	for j := 8; j < size; j++ {
+		if buf[j] != byte(i+j) {
+			return i, fmt.Errorf("message %d is corrupt at byte %d", i, j)
+		}
+	}
+	return i, nil
+}
+
+// A Producer writes numbered messages to a Tee. Numbers start at 0.
+type Producer struct {
+	Tee  *nbtee2.Tee
+	Size int // message size; default DefaultSize
+
+	next int
+	mtx  sync.Mutex
+}
+
+// Write writes the next n messages, and returns the number of the
+// first one.
+func (p *Producer) Write(n int) int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	first := p.next
+	for ; n > 0; n-- {
+		p.Tee.Write(Numbered(p.next, p.size()))
+		p.next++
+	}
+	return first
+}
+
+// Next returns the number of the next message to be written, i.e.,
+// the number of messages written so far.
+func (p *Producer) Next() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.next
+}
+
+func (p *Producer) size() int {
+	if p.Size == 0 {
+		return DefaultSize
+	}
+	return p.Size
+}
+
+// A Report describes which numbered messages a consumer received.
+type Report struct {
+	Delivered []int
+	Dropped   []int
+}
+
+// Verify checks that msgs (as returned by ReadMessage) are valid
+// numbered messages of the given size, in increasing order, without
+// duplicates, and with Gap set exactly where messages are missing.
+// It returns a report of which messages in the range [from, to) were
+// delivered and which were dropped.
+func Verify(msgs []nbtee2.Message, size, from, to int) (Report, error) {
+	var rep Report
+	prev := from - 1
+	for n, msg := range msgs {
+		i, err := Parse(msg.Data, size)
+		if err != nil {
+			return rep, err
+		}
+		if i <= prev {
+			return rep, fmt.Errorf("message %d arrived after message %d", i, prev)
+		}
+		if i >= to {
+			return rep, fmt.Errorf("message %d is out of range [%d, %d)", i, from, to)
+		}
+		if n > 0 && msg.Gap != (i != prev+1) {
+			return rep, fmt.Errorf("message %d (after %d) has Gap=%v", i, prev, msg.Gap)
+		}
+		rep.Delivered = append(rep.Delivered, i)
+		for prev++; prev < i; prev++ {
+			rep.Dropped = append(rep.Dropped, prev)
+		}
+	}
+	for prev++; prev < to; prev++ {
+		rep.Dropped = append(rep.Dropped, prev)
+	}
+	return rep, nil
+}
+
+// VerifyBytes is like Verify, for consumers that read a byte stream
+// (which has no gap markers) instead of messages.
+func VerifyBytes(data []byte, size, from, to int) (Report, error) {
+	if size < 8 {
+		size = 8
+	}
+	if len(data)%size != 0 {
+		return Report{}, fmt.Errorf("stream has %d bytes, not a multiple of message size %d", len(data), size)
+	}
+	var msgs []nbtee2.Message
+	prev := from - 1
+	for ; len(data) > 0; data = data[size:] {
+		msg := nbtee2.Message{Data: data[:size]}
+		i, _ := Parse(msg.Data, size)
+		msg.Gap = i != prev+1
+		prev = i
+		msgs = append(msgs, msg)
+	}
+	return Verify(msgs, size, from, to)
+}
+
+// A Consumer reads messages from a Reader only when told to,
+// simulating a subscriber that falls behind by a controlled amount.
+type Consumer struct {
+	R        *nbtee2.Reader
+	Messages []nbtee2.Message // messages read so far
+}
+
+// Read reads n messages, blocking until they arrive. It returns an
+// error (e.g., io.EOF) if the Reader does.
+func (c *Consumer) Read(n int) error {
+	for ; n > 0; n-- {
+		msg, err := c.R.ReadMessage()
+		if err != nil {
+			return err
+		}
+		c.Messages = append(c.Messages, msg)
+	}
+	return nil
+}
+
+// Drain reads messages until the Reader returns an error, and
+// returns nil if the error was io.EOF.
+func (c *Consumer) Drain() error {
+	for {
+		msg, err := c.R.ReadMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		c.Messages = append(c.Messages, msg)
+	}
+}
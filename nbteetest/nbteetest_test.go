@@ -0,0 +1,90 @@
+package nbteetest
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tomclegg/nbtee2"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type Suite struct{}
+
+var _ = check.Suite(&Suite{})
+
+func (s *Suite) TestNumbered(c *check.C) {
+	for _, size := range []int{0, 8, 20} {
+		i, err := Parse(Numbered(1234, size), size)
+		c.Check(err, check.IsNil)
+		c.Check(i, check.Equals, 1234)
+	}
+	buf := Numbered(5, 16)
+	buf[12]++
+	_, err := Parse(buf, 16)
+	c.Check(err, check.ErrorMatches, "message 5 is corrupt.*")
+	_, err = Parse(buf[:10], 16)
+	c.Check(err, check.ErrorMatches, "message has 10 bytes.*")
+}
+
+func (s *Suite) TestConsumerVerify(c *check.C) {
+	w := &nbtee2.Tee{}
+	p := &Producer{Tee: w}
+	p.Write(2)
+	cons := &Consumer{R: w.NewReader(0, 2)}
+	from := p.Write(4) // #4 and #5 are dropped
+	c.Check(cons.Read(2), check.IsNil)
+	p.Write(1)
+	w.Close()
+	c.Check(cons.Drain(), check.IsNil)
+
+	rep, err := Verify(cons.Messages, DefaultSize, from, p.Next())
+	c.Check(err, check.IsNil)
+	c.Check(rep.Delivered, check.DeepEquals, []int{2, 3, 6})
+	c.Check(rep.Dropped, check.DeepEquals, []int{4, 5})
+
+	cons.Messages[2].Gap = false
+	_, err = Verify(cons.Messages, DefaultSize, from, p.Next())
+	c.Check(err, check.ErrorMatches, `message 6 \(after 3\) has Gap=false`)
+	cons.Messages[0], cons.Messages[1] = cons.Messages[1], cons.Messages[0]
+	_, err = Verify(cons.Messages, DefaultSize, from, p.Next())
+	c.Check(err, check.ErrorMatches, `message 2 arrived after message 3`)
+}
+
+func (s *Suite) TestSlowWriter(c *check.C) {
+	w := &nbtee2.Tee{}
+	p := &Producer{Tee: w, Size: 8}
+	r := w.NewReader(0, 2)
+	sw := &SlowWriter{}
+	done := make(chan error)
+	go func() {
+		_, err := r.WriteTo(sw)
+		done <- err
+	}()
+	p.Write(1)
+	// Wait for the reader to get stuck writing message 0.
+	for w.Stats().Readers[0].Buffered > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Write(10)
+	c.Check(sw.Writes(), check.Equals, 0)
+	sw.Allow(100)
+	w.Close()
+	c.Check(<-done, check.Equals, io.EOF)
+	c.Check(sw.Writes(), check.Equals, 3)
+	rep, err := VerifyBytes(sw.Bytes(), 8, 0, p.Next())
+	c.Check(err, check.IsNil)
+	c.Check(rep.Delivered, check.DeepEquals, []int{0, 1, 2})
+	c.Check(rep.Dropped, check.DeepEquals, []int{3, 4, 5, 6, 7, 8, 9, 10})
+
+	sw = &SlowWriter{}
+	go func() {
+		_, err := sw.Write([]byte{1})
+		done <- err
+	}()
+	sw.Fail(errors.New("broken pipe"))
+	c.Check(<-done, check.ErrorMatches, "broken pipe")
+}
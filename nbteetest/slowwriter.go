@@ -0,0 +1,73 @@
+package nbteetest
+
+import (
+	"bytes"
+	"sync"
+)
+
+// A SlowWriter is an io.Writer whose Write calls block until the
+// test allows them, simulating a slow or stuck downstream
+// connection. Use it as the destination for Reader.WriteTo,
+// nbtee2.PumpTo, etc.
+type SlowWriter struct {
+	buf     bytes.Buffer
+	allowed int
+	writes  int
+	err     error
+	cond    *sync.Cond
+	mtx     sync.Mutex
+	once    sync.Once
+}
+
+func (sw *SlowWriter) init() {
+	sw.once.Do(func() { sw.cond = sync.NewCond(&sw.mtx) })
+}
+
+// Allow lets n more Write calls proceed.
+func (sw *SlowWriter) Allow(n int) {
+	sw.init()
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	sw.allowed += n
+	sw.cond.Broadcast()
+}
+
+// Fail makes pending and future Write calls return err.
+func (sw *SlowWriter) Fail(err error) {
+	sw.init()
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	sw.err = err
+	sw.cond.Broadcast()
+}
+
+// Write blocks until it is allowed (see Allow) or failed (see Fail).
+func (sw *SlowWriter) Write(p []byte) (int, error) {
+	sw.init()
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	for sw.allowed == 0 && sw.err == nil {
+		sw.cond.Wait()
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	sw.allowed--
+	sw.writes++
+	return sw.buf.Write(p)
+}
+
+// Bytes returns a copy of everything written so far.
+func (sw *SlowWriter) Bytes() []byte {
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	return append([]byte(nil), sw.buf.Bytes()...)
+}
+
+// Writes returns the number of Write calls that have completed
+// successfully.
+func (sw *SlowWriter) Writes() int {
+	sw.mtx.Lock()
+	defer sw.mtx.Unlock()
+	return sw.writes
+}
@@ -0,0 +1,150 @@
+// Package nbteewt serves an nbtee2.Tee to WebTransport clients, such
+// as browsers, over HTTP/3. Each write is sent on its own QUIC stream
+// (or as a datagram), so a lost packet delays only the write it
+// belongs to, instead of everything after it as it would on a TCP
+// connection. This suits lossy-by-design streams on lossy networks,
+// where a late write is worth less than the next one.
+//
+// It is a separate module so that nbtee2 itself doesn't depend on a
+// QUIC implementation.
+package nbteewt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+	"github.com/tomclegg/nbtee2"
+)
+
+// HeaderSize is the size of the header at the start of each stream
+// or datagram: the write's sequence number (see nbtee2.Message), as a
+// big-endian uint64.
+const HeaderSize = 8
+
+// A Handler is an http.Handler that upgrades each request to a
+// WebTransport session and streams the Tee's writes to it until the
+// client closes the session or the Tee is closed.
+//
+// Each write is sent as a unidirectional stream containing a header
+// (see HeaderSize) followed by the write's data. Streams can arrive
+// in any order; a client that cares can put them back in order by
+// sequence number. Missing numbers are writes the client didn't get,
+// because it fell behind (as with any other reader) or its stream
+// was reset (see StreamTimeout).
+type Handler struct {
+	Tee *nbtee2.Tee
+
+	// Server used to upgrade requests. Its H3 server must be set
+	// up with webtransport.ConfigureHTTP3Server, with
+	// EnableDatagrams and EnableStreamResetPartialDelivery set in
+	// its QUICConfig, and route requests to this Handler.
+	Server *webtransport.Server
+
+	// Options for each session's reader.
+	ReaderOptions []nbtee2.ReaderOption
+
+	// If true, writes that fit in a datagram are sent as datagrams
+	// instead of streams. Datagrams are cheaper, but are not
+	// retransmitted if lost. Larger writes are still sent as
+	// streams.
+	Datagrams bool
+
+	// If nonzero, a stream that still hasn't been sent this long
+	// after it was opened is reset, so it stops using bandwidth
+	// the client needs for newer writes.
+	StreamTimeout time.Duration
+
+	// How long to keep a session open after the Tee is closed, so
+	// writes in flight can arrive, unless the client closes it
+	// first. Default 1s.
+	CloseTimeout time.Duration
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	sess, err := h.Server.Upgrade(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serve(sess)
+}
+
+// Stream from a new reader to sess until the session or the Tee is
+// closed.
+func (h *Handler) serve(sess *webtransport.Session) {
+	ctx, cancel := context.WithCancel(sess.Context())
+	defer cancel()
+	r := h.Tee.NewLiveReader(ctx, h.ReaderOptions...)
+	defer r.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			wg.Wait()
+			h.linger(sess)
+			sess.CloseWithError(0, "")
+			return
+		} else if err != nil {
+			return
+		}
+		buf := make([]byte, HeaderSize+len(msg.Data))
+		binary.BigEndian.PutUint64(buf, msg.Seq)
+		copy(buf[HeaderSize:], msg.Data)
+		if h.Datagrams {
+			var tooLarge *quic.DatagramTooLargeError
+			if err := sess.SendDatagram(buf); err == nil {
+				continue
+			} else if !errors.As(err, &tooLarge) {
+				return
+			}
+		}
+		// This waits if the client has as many streams open as
+		// it allows, in which case the reader buffers (and
+		// eventually drops) writes, as with a slow TCP client.
+		str, err := sess.OpenUniStreamSync(ctx)
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.send(str, buf)
+		}()
+	}
+}
+
+// Send buf on str and close it, or reset it if that takes longer
+// than StreamTimeout.
+func (h *Handler) send(str *webtransport.SendStream, buf []byte) {
+	if h.StreamTimeout > 0 {
+		str.SetWriteDeadline(time.Now().Add(h.StreamTimeout))
+	}
+	if _, err := str.Write(buf); err != nil {
+		str.CancelWrite(0)
+		return
+	}
+	str.Close()
+}
+
+// Wait for the client to close sess, or CloseTimeout.
+func (h *Handler) linger(sess *webtransport.Session) {
+	d := h.CloseTimeout
+	if d <= 0 {
+		d = time.Second
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-sess.Context().Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,153 @@
+package nbteewt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"github.com/tomclegg/nbtee2"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type Suite struct{}
+
+var _ = check.Suite(&Suite{})
+
+// Servers started by the current test.
+var servers []*webtransport.Server
+
+func (s *Suite) TearDownTest(c *check.C) {
+	for _, srv := range servers {
+		srv.Close()
+	}
+	servers = nil
+}
+
+// Start a WebTransport server for h, and return a client session
+// connected to it. The server is closed when the test finishes.
+func serve(c *check.C, h *Handler) *webtransport.Session {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, check.IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, check.IsNil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	h3 := &http3.Server{
+		TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}),
+		QUICConfig: &quic.Config{EnableDatagrams: true, EnableStreamResetPartialDelivery: true},
+		Handler:    h,
+	}
+	webtransport.ConfigureHTTP3Server(h3)
+	h.Server = &webtransport.Server{H3: h3}
+	go h.Server.Serve(pc)
+	servers = append(servers, h.Server)
+
+	client := &webtransport.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, NextProtos: []string{http3.NextProtoH3}},
+		QUICConfig:      &quic.Config{EnableDatagrams: true, EnableStreamResetPartialDelivery: true},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, sess, err := client.Dial(ctx, "https://"+pc.LocalAddr().String()+"/", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	for len(h.Tee.Stats().Readers) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return sess
+}
+
+// Read the next stream, and return its sequence number and data.
+func readStream(c *check.C, sess *webtransport.Session) (uint64, []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	str, err := sess.AcceptUniStream(ctx)
+	c.Assert(err, check.IsNil)
+	buf, err := ioutil.ReadAll(str)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(buf) >= HeaderSize, check.Equals, true)
+	return binary.BigEndian.Uint64(buf), buf[HeaderSize:]
+}
+
+func (s *Suite) TestStreams(c *check.C) {
+	w := &nbtee2.Tee{}
+	sess := serve(c, &Handler{Tee: w, CloseTimeout: 10 * time.Second})
+	w.Write([]byte("foo"))
+	w.Write([]byte("bar"))
+	w.Write(bytes.Repeat([]byte{'x'}, 100000))
+	got := map[uint64]string{}
+	for i := 0; i < 3; i++ {
+		seq, data := readStream(c, sess)
+		got[seq] = string(data)
+	}
+	c.Check(got[1], check.Equals, "foo")
+	c.Check(got[2], check.Equals, "bar")
+	c.Check(got[3], check.HasLen, 100000)
+
+	// After the Tee is closed, the server waits for the client to
+	// close the session.
+	w.Close()
+	select {
+	case <-sess.Context().Done():
+		c.Error("session closed early")
+	case <-time.After(50 * time.Millisecond):
+	}
+	sess.CloseWithError(0, "")
+}
+
+func (s *Suite) TestDatagrams(c *check.C) {
+	w := &nbtee2.Tee{}
+	sess := serve(c, &Handler{Tee: w, Datagrams: true, CloseTimeout: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	w.Write([]byte("foo"))
+	buf, err := sess.ReceiveDatagram(ctx)
+	c.Assert(err, check.IsNil)
+	c.Check(binary.BigEndian.Uint64(buf), check.Equals, uint64(1))
+	c.Check(string(buf[HeaderSize:]), check.Equals, "foo")
+
+	// Too big for a datagram.
+	w.Write(bytes.Repeat([]byte{'x'}, 10000))
+	seq, data := readStream(c, sess)
+	c.Check(seq, check.Equals, uint64(2))
+	c.Check(data, check.HasLen, 10000)
+
+	// The server closes the session after CloseTimeout.
+	w.Close()
+	select {
+	case <-sess.Context().Done():
+	case <-ctx.Done():
+		c.Error("session not closed")
+	}
+}
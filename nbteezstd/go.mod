@@ -0,0 +1,16 @@
+module github.com/tomclegg/nbtee2/nbteezstd
+
+go 1.25
+
+require (
+	github.com/klauspost/compress v1.20.1
+	github.com/tomclegg/nbtee2 v0.0.0
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+)
+
+require (
+	github.com/kr/pretty v0.2.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+)
+
+replace github.com/tomclegg/nbtee2 => ../
@@ -0,0 +1,41 @@
+// Package nbteezstd provides a zstd content coding for
+// nbtee2.StreamHandler, optionally with a dictionary, which helps a
+// lot with streams of small similar writes (such as JSON events) that
+// a dictionary-less compressor mostly sees for the first time.
+//
+// It is a separate module so that nbtee2 itself doesn't depend on a
+// zstd implementation.
+package nbteezstd
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/tomclegg/nbtee2"
+)
+
+// Compression returns a zstd Compression with the given level.
+//
+// If dict is not nil, it is used as the compression dictionary. It
+// must be in zstd's dictionary format, as produced by "zstd --train"
+// or zstd.BuildDict. Clients need the same dictionary to decompress
+// the response; browsers don't have it, so this is for clients that
+// get it some other way.
+func Compression(level zstd.EncoderLevel, dict []byte) nbtee2.Compression {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(level),
+		// Each client has its own compressor, and a response
+		// stream is sequential anyway, so don't start a set of
+		// goroutines per client.
+		zstd.WithEncoderConcurrency(1),
+	}
+	if dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	return nbtee2.Compression{
+		Name: "zstd",
+		NewWriter: func(w io.Writer) (nbtee2.CompressWriter, error) {
+			return zstd.NewWriter(w, opts...)
+		},
+	}
+}
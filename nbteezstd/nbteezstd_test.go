@@ -0,0 +1,125 @@
+package nbteezstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/tomclegg/nbtee2"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type Suite struct{}
+
+var _ = check.Suite(&Suite{})
+
+// Wait until w has n readers.
+func waitReaders(c *check.C, w *nbtee2.Tee, n int) {
+	for deadline := time.Now().Add(10 * time.Second); len(w.Stats().Readers) != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for %d readers", n)
+		}
+	}
+}
+
+func event(i int) []byte {
+	return []byte(fmt.Sprintf(`{"type":"temperature","sensor":"sensor-%d","unit":"celsius","value":%d}`+"\n", i%7, 20+i%13))
+}
+
+func buildDict(c *check.C) []byte {
+	var contents [][]byte
+	var hist []byte
+	for i := 0; i < 200; i++ {
+		contents = append(contents, event(i))
+		if i < 20 {
+			hist = append(hist, event(i)...)
+		}
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1234,
+		Contents: contents,
+		History:  hist,
+		Offsets:  [3]int{1, 4, 8},
+	})
+	c.Assert(err, check.IsNil)
+	return dict
+}
+
+// Start a StreamHandler with comp, and return its zstd response.
+func get(c *check.C, w *nbtee2.Tee, comp nbtee2.Compression) (*httptest.Server, *http.Response) {
+	srv := httptest.NewServer(&nbtee2.StreamHandler{
+		Tee:         w,
+		Compression: []nbtee2.Compression{comp, nbtee2.Gzip(1)},
+		// Enough to buffer every write in a test.
+		ReaderOptions: []nbtee2.ReaderOption{nbtee2.WithWatermarks(0, 1000)},
+	})
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Check(resp.Header.Get("Content-Encoding"), check.Equals, "zstd")
+	waitReaders(c, w, 1)
+	return srv, resp
+}
+
+func (s *Suite) TestCompression(c *check.C) {
+	w := &nbtee2.Tee{}
+	srv, resp := get(c, w, Compression(zstd.SpeedFastest, nil))
+	defer srv.Close()
+	defer resp.Body.Close()
+	zr, err := zstd.NewReader(resp.Body)
+	c.Assert(err, check.IsNil)
+	defer zr.Close()
+
+	// Each write is flushed, so the client can decompress it
+	// without waiting for more.
+	w.Write([]byte("foo"))
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(zr, buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+	w.Write([]byte("bar"))
+	w.Close()
+	rest, err := ioutil.ReadAll(zr)
+	c.Check(err, check.IsNil)
+	c.Check(string(rest), check.Equals, "bar")
+}
+
+func (s *Suite) TestDictionary(c *check.C) {
+	dict := buildDict(c)
+	w := &nbtee2.Tee{}
+	srv, resp := get(c, w, Compression(zstd.SpeedDefault, dict))
+	defer srv.Close()
+	defer resp.Body.Close()
+	var expect []byte
+	for i := 0; i < 100; i++ {
+		expect = append(expect, event(i)...)
+		w.Write(event(i))
+	}
+	w.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+
+	zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderDicts(dict))
+	c.Assert(err, check.IsNil)
+	defer zr.Close()
+	got, err := ioutil.ReadAll(zr)
+	c.Assert(err, check.IsNil)
+	c.Check(string(got), check.Equals, string(expect))
+
+	// A client without the dictionary can't decompress it.
+	zr, err = zstd.NewReader(bytes.NewReader(body))
+	c.Assert(err, check.IsNil)
+	defer zr.Close()
+	_, err = ioutil.ReadAll(zr)
+	c.Check(err, check.NotNil)
+}
@@ -0,0 +1,85 @@
+package nbtee2
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var oggCapture = []byte("OggS")
+
+// An OggWriter is an io.Writer that sends an Ogg stream (e.g., Vorbis
+// or Opus audio, as served by Icecast) to a Tee one page at a time,
+// so readers that fall behind drop whole pages.
+//
+// The header pages at the start of each stream (the BOS pages and
+// the following pages with granule position 0, which carry the codec
+// headers) are replayed to each new reader before it joins the live
+// stream. Readers skip pages that begin with the continuation of a
+// packet when they are starting or have just dropped data.
+//
+// Page checksums are not verified. Data that doesn't look like an
+// Ogg page is skipped.
+type OggWriter struct {
+	Tee *Tee
+
+	buf      []byte
+	inHeader bool
+}
+
+// Write implements io.Writer. Data is sent to the Tee as each page is
+// completed. The returned error is always nil.
+func (ow *OggWriter) Write(p []byte) (int, error) {
+	ow.buf = append(ow.buf, p...)
+	buf := ow.buf
+	for {
+		i := bytes.Index(buf, oggCapture)
+		if i < 0 {
+			// Keep a possible partial capture pattern.
+			if len(buf) > len(oggCapture)-1 {
+				buf = buf[len(buf)-len(oggCapture)+1:]
+			}
+			break
+		}
+		buf = buf[i:]
+		size := oggPageSize(buf)
+		if size == 0 || len(buf) < size {
+			break
+		}
+		ow.sendPage(append([]byte(nil), buf[:size]...))
+		buf = buf[size:]
+	}
+	ow.buf = append(ow.buf[:0], buf...)
+	return len(p), nil
+}
+
+// Return the size of the page at the start of buf, or 0 if buf is
+// too short to tell.
+func oggPageSize(buf []byte) int {
+	if len(buf) < 27 {
+		return 0
+	}
+	nsegs := int(buf[26])
+	if len(buf) < 27+nsegs {
+		return 0
+	}
+	size := 27 + nsegs
+	for _, seg := range buf[27 : 27+nsegs] {
+		size += int(seg)
+	}
+	return size
+}
+
+func (ow *OggWriter) sendPage(page []byte) {
+	headerType := page[5]
+	granule := binary.LittleEndian.Uint64(page[6:14])
+	switch {
+	case headerType&0x02 != 0: // beginning of stream
+		ow.Tee.sendHeader(page, !ow.inHeader)
+		ow.inHeader = true
+	case ow.inHeader && (granule == 0 || granule == ^uint64(0)):
+		ow.Tee.sendHeader(page, false)
+	default:
+		ow.inHeader = false
+		ow.Tee.sendUnit(page, headerType&0x01 != 0)
+	}
+}
@@ -0,0 +1,85 @@
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	check "gopkg.in/check.v1"
+)
+
+// Return an Ogg page with the given header type, granule position,
+// and data (which must be less than 255 bytes).
+func oggPage(headerType byte, granule uint64, data string) []byte {
+	page := make([]byte, 27, 28+len(data))
+	copy(page, oggCapture)
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	page[26] = 1
+	page = append(page, byte(len(data)))
+	return append(page, data...)
+}
+
+func (s *Suite) TestOggWriter(c *check.C) {
+	w := &Tee{}
+	ow := &OggWriter{Tee: w}
+	bos := oggPage(0x02, 0, "bos")
+	hdr := oggPage(0, 0, "hdr")
+	pageA := oggPage(0, 100, "A")
+	pageB := oggPage(0x01, 200, "B")
+	pageC := oggPage(0, 300, "C")
+	pageD := oggPage(0, 400, "D")
+
+	r1 := w.newLiveReader(context.Background())
+	f := &Faults{}
+	r4 := w.newLiveReader(context.Background(), WithFaults(f))
+	ow.Write(append([]byte("garbage"), bos...))
+	ow.Write(hdr[:10])
+	ow.Write(append(hdr[10:], pageA[:3]...))
+	ow.Write(pageA[3:])
+	c.Check(w.Stats().Writes, check.Equals, int64(3))
+	f.Overflow()
+	r3 := w.newLiveReader(context.Background())
+	ow.Write(append(append([]byte("Og"), pageB...), pageC...))
+	r2 := w.newLiveReader(context.Background())
+	ow.Write(pageD)
+	w.Close()
+
+	for _, trial := range []struct {
+		r     *Reader
+		pages [][]byte
+		gaps  []bool
+	}{
+		{r1, [][]byte{bos, hdr, pageA, pageB, pageC, pageD}, []bool{false, false, false, false, false, false}},
+		{r2, [][]byte{bos, hdr, pageD}, []bool{false, false, false}},
+		{r3, [][]byte{bos, hdr, pageC, pageD}, []bool{false, false, false, false}},
+		{r4, [][]byte{pageC, pageD}, []bool{true, false}},
+	} {
+		for i, page := range trial.pages {
+			msg, err := trial.r.ReadMessage()
+			c.Assert(err, check.IsNil)
+			c.Check(msg.Data, check.DeepEquals, page)
+			c.Check(msg.Gap, check.Equals, trial.gaps[i])
+		}
+		_, err := trial.r.ReadMessage()
+		c.Check(err, check.Equals, io.EOF)
+	}
+}
+
+func (s *Suite) TestOggWriterNewStream(c *check.C) {
+	w := &Tee{}
+	ow := &OggWriter{Tee: w}
+	ow.Write(oggPage(0x02, 0, "bos1"))
+	ow.Write(oggPage(0, 100, "data1"))
+	bos2 := oggPage(0x02, 0, "bos2")
+	ow.Write(bos2)
+	r := w.newLiveReader(context.Background())
+	ow.Write(oggPage(0, 100, "data2"))
+	w.Close()
+	msg, err := r.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Check(msg.Data, check.DeepEquals, bos2)
+	msg, err = r.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Check(string(msg.Data[28:]), check.Equals, "data2")
+}
@@ -0,0 +1,161 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// An Option configures a Tee created by New.
+type Option func(*Tee)
+
+// A Clock tells the time. The default is the system clock; tests
+// can substitute a fake one with WithClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// A TimerClock is a Clock that can also wait. If the Clock passed to
+// WithClock is a TimerClock, the Tee's time-driven features (pacing,
+// fault delays, StreamHandler's FlushInterval, and helpers such as
+// Watchdog, Breaker, Recorder, and StatsdEmitter that run against the
+// Tee) wait on its timers instead of the system clock's, so tests can
+// advance a fake clock instead of sleeping. Network deadlines (e.g.,
+// Server.WriteTimeout) always use the system clock, because they are
+// enforced by the operating system.
+type TimerClock interface {
+	Clock
+
+	// NewTimer returns a Timer that sends the clock's current time
+	// on its channel once d has elapsed on the clock.
+	NewTimer(d time.Duration) Timer
+}
+
+// A Timer is a single event created by TimerClock.NewTimer, like a
+// time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing. It returns false if the
+	// timer has already fired or been stopped.
+	Stop() bool
+}
+
+// A Logger receives occasional diagnostic messages, such as a Breaker
+// shedding readers. *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// New returns a Tee configured with the given options. A Tee created
+// with &Tee{} has the same defaults as New().
+func New(opts ...Option) *Tee {
+	w := &Tee{}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Pipe returns a new Tee (configured with the given options) as an
+// io.WriteCloser, and a function that returns new readers for it, for
+// code written in terms of io.Pipe-style composition that doesn't
+// need the concrete types. Each reader starts with the next write,
+// and must be closed after use.
+func Pipe(opts ...Option) (io.WriteCloser, func(ctx context.Context, opts ...ReaderOption) io.ReadCloser) {
+	w := New(opts...)
+	return w, func(ctx context.Context, opts ...ReaderOption) io.ReadCloser {
+		return w.newLiveReader(ctx, opts...)
+	}
+}
+
+// WithRetain sets Tee.Retain.
+func WithRetain(d time.Duration) Option {
+	return func(w *Tee) {
+		w.Retain = d
+	}
+}
+
+// WithMaxBuffered sets Tee.MaxBuffered.
+func WithMaxBuffered(n int64) Option {
+	return func(w *Tee) {
+		w.MaxBuffered = n
+	}
+}
+
+// WithReaderDefaults sets options (e.g., WithWatermarks) applied to
+// every reader created from the Tee, before the options passed when
+// creating the reader. NewReader and NewReaderContext always override
+// the default watermarks with their arguments.
+func WithReaderDefaults(opts ...ReaderOption) Option {
+	return func(w *Tee) {
+		w.readerDefaults = append(w.readerDefaults, opts...)
+	}
+}
+
+// WithClock makes the Tee use c instead of the system clock to
+// timestamp writes, expire retained writes, and report stats. If c is
+// a TimerClock, it is also used for waiting.
+func WithClock(c Clock) Option {
+	return func(w *Tee) {
+		w.clock = c
+	}
+}
+
+// WithLogger sets the Tee's Logger. The default is to discard
+// diagnostic messages.
+func WithLogger(l Logger) Option {
+	return func(w *Tee) {
+		w.logger = l
+	}
+}
+
+func (w *Tee) now() time.Time {
+	return clockNow(w.clock)
+}
+
+// Wait for d on w's clock. Returns ctx.Err() if ctx is done first.
+func (w *Tee) sleep(ctx context.Context, d time.Duration) error {
+	return clockSleep(ctx, w.clock, d)
+}
+
+// Return the time on c, or the system clock if c is nil.
+func clockNow(c Clock) time.Time {
+	if c != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Return a timer on c if it is a TimerClock, otherwise on the system
+// clock.
+func clockTimer(c Clock, d time.Duration) Timer {
+	if tc, ok := c.(TimerClock); ok {
+		return tc.NewTimer(d)
+	}
+	return systemTimer{time.NewTimer(d)}
+}
+
+// Wait for d on c (see clockTimer). Returns ctx.Err() if ctx is done
+// first.
+func clockSleep(ctx context.Context, c Clock, d time.Duration) error {
+	timer := clockTimer(c, d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+func (w *Tee) logf(format string, args ...interface{}) {
+	if w.logger != nil {
+		w.logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,284 @@
+package nbtee2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (fc *fakeClock) Now() time.Time { return fc.t }
+
+// A fakeTimerClock only moves when Advance is called.
+type fakeTimerClock struct {
+	mtx    sync.Mutex
+	t      time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	fc  *fakeTimerClock
+	at  time.Time
+	c   chan time.Time
+	off bool
+}
+
+func (fc *fakeTimerClock) Now() time.Time {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	return fc.t
+}
+
+func (fc *fakeTimerClock) NewTimer(d time.Duration) Timer {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	ft := &fakeTimer{fc: fc, at: fc.t.Add(d), c: make(chan time.Time, 1)}
+	fc.timers = append(fc.timers, ft)
+	return ft
+}
+
+// Advance moves the clock forward by d and fires timers that are due.
+func (fc *fakeTimerClock) Advance(d time.Duration) {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	fc.t = fc.t.Add(d)
+	pending := fc.timers[:0]
+	for _, ft := range fc.timers {
+		if ft.off {
+			continue
+		} else if ft.at.After(fc.t) {
+			pending = append(pending, ft)
+		} else {
+			ft.off = true
+			ft.c <- fc.t
+		}
+	}
+	fc.timers = pending
+}
+
+// Wait until n timers are pending.
+func (fc *fakeTimerClock) waitTimers(c *check.C, n int) {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		fc.mtx.Lock()
+		pending := 0
+		for _, ft := range fc.timers {
+			if !ft.off {
+				pending++
+			}
+		}
+		fc.mtx.Unlock()
+		if pending == n {
+			return
+		}
+	}
+	c.Fatalf("timed out waiting for %d timers", n)
+}
+
+func (ft *fakeTimer) C() <-chan time.Time { return ft.c }
+
+func (ft *fakeTimer) Stop() bool {
+	ft.fc.mtx.Lock()
+	defer ft.fc.mtx.Unlock()
+	wasOn := !ft.off
+	ft.off = true
+	return wasOn
+}
+
+func (s *Suite) TestNew(c *check.C) {
+	clock := &fakeClock{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	var logbuf bytes.Buffer
+	w := New(
+		WithRetain(time.Minute),
+		WithMaxBuffered(1000),
+		WithReaderDefaults(WithWatermarks(0, 3)),
+		WithClock(clock),
+		WithLogger(log.New(&logbuf, "", 0)),
+	)
+	c.Check(w.Retain, check.Equals, time.Minute)
+	c.Check(w.MaxBuffered, check.Equals, int64(1000))
+
+	r := w.newLiveReader(context.Background())
+	defer r.Close()
+	c.Check(r.highwater, check.Equals, 3)
+	c.Check(w.newLiveReader(context.Background(), WithWatermarks(0, 5)).highwater, check.Equals, 5)
+
+	w.Write([]byte{1})
+	msg, err := r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Time, check.Equals, clock.t)
+	c.Check(w.Stats().Started, check.Equals, clock.t)
+
+	// Retained writes expire according to the clock.
+	c.Check(w.Snapshot(), check.HasLen, 1)
+	clock.t = clock.t.Add(2 * time.Minute)
+	c.Check(w.Snapshot(), check.HasLen, 0)
+
+	w.logf("hello %d", 1)
+	c.Check(logbuf.String(), check.Equals, "hello 1\n")
+	(&Tee{}).logf("discarded")
+}
+
+func (s *Suite) TestPipe(c *check.C) {
+	pw, newReader := Pipe(WithReaderDefaults(WithWatermarks(0, 3)))
+	r1 := newReader(context.Background())
+	r2 := newReader(context.Background(), WithWatermarks(0, 10))
+	for i := byte(1); i <= 5; i++ {
+		pw.Write([]byte{i})
+	}
+	pw.Close()
+	buf, err := ioutil.ReadAll(r1)
+	c.Check(err, check.IsNil)
+	c.Check(len(buf) < 5, check.Equals, true)
+	buf, err = ioutil.ReadAll(r2)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.DeepEquals, []byte{1, 2, 3, 4, 5})
+	c.Check(r1.Close(), check.IsNil)
+	c.Check(r2.Close(), check.IsNil)
+}
+
+func (s *Suite) TestTimerClockPacing(c *check.C) {
+	clock := &fakeTimerClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	w := New(WithClock(clock), WithRetain(2*time.Hour))
+	w.Write([]byte("a"))
+	clock.Advance(time.Hour)
+	w.Write([]byte("b"))
+	r := w.NewReaderAt(context.Background(), time.Time{}, WithPacing())
+	w.Close()
+	got := make(chan string, 2)
+	go func() {
+		r.WriteTo(writerFunc(func(p []byte) (int, error) {
+			got <- string(p)
+			return len(p), nil
+		}))
+		close(got)
+	}()
+	c.Check(<-got, check.Equals, "a")
+
+	// "b" is replayed an hour after "a", on the fake clock.
+	clock.waitTimers(c, 1)
+	clock.Advance(time.Hour - time.Second)
+	clock.waitTimers(c, 1)
+	c.Check(len(got), check.Equals, 0)
+	clock.Advance(time.Second)
+	c.Check(<-got, check.Equals, "b")
+	_, ok := <-got
+	c.Check(ok, check.Equals, false)
+}
+
+func (s *Suite) TestTimerClockWatchdog(c *check.C) {
+	clock := &fakeTimerClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	w := New(WithClock(clock))
+	r := w.NewReader(0, 4)
+	defer r.Close()
+	stuck := make(chan uint64, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wd := &Watchdog{Tee: w, Timeout: time.Minute, Stuck: func(rs ReaderStats) { stuck <- rs.ID }}
+	done := make(chan error)
+	go func() { done <- wd.Run(ctx) }()
+
+	w.Write([]byte{1})
+	for i := 0; i < 3; i++ {
+		clock.waitTimers(c, 1)
+		clock.Advance(wd.Timeout / 4)
+	}
+	clock.waitTimers(c, 1)
+	c.Check(len(stuck), check.Equals, 0)
+	clock.Advance(wd.Timeout / 4)
+	c.Check(<-stuck, check.Equals, r.id)
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+}
+
+func (s *Suite) TestTimerClockRegistry(c *check.C) {
+	clock := &fakeTimerClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	reg := &Registry{
+		IdleTTL: time.Minute,
+		New:     func(string) *Tee { return New(WithClock(clock)) },
+		Clock:   clock,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reg.Run(ctx)
+	reg.Get("a")
+	clock.waitTimers(c, 1)
+	clock.Advance(reg.IdleTTL / 2)
+	clock.waitTimers(c, 1)
+	c.Check(reg.Names(), check.DeepEquals, []string{"a"})
+	clock.Advance(reg.IdleTTL / 2)
+	clock.waitTimers(c, 1)
+	c.Check(reg.Names(), check.HasLen, 0)
+}
+
+func (s *Suite) TestTimerClockStreamHandler(c *check.C) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := &fakeTimerClock{t: start}
+	w := New(WithClock(clock))
+	disconnected := make(chan ConnStats, 1)
+	h := &StreamHandler{
+		Tee:           w,
+		FlushInterval: time.Second,
+		Disconnected:  func(cs ConnStats) { disconnected <- cs },
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	waitReaders(c, w, 1)
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 3)
+		io.ReadFull(resp.Body, buf)
+		got <- string(buf)
+	}()
+
+	// "foo" is flushed when the interval passes on the fake clock.
+	w.Write([]byte("foo"))
+	clock.waitTimers(c, 1)
+	c.Check(len(got), check.Equals, 0)
+	clock.Advance(time.Second)
+	c.Check(<-got, check.Equals, "foo")
+
+	w.Close()
+	cs := <-disconnected
+	c.Check(cs.Start, check.Equals, start)
+	c.Check(cs.Duration, check.Equals, time.Second)
+}
+
+func (s *Suite) TestTimerClockDialBackoff(c *check.C) {
+	clock := &fakeTimerClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	r := Dial(srv.URL, WithBackoff(time.Hour, time.Hour), WithBackoffClock(clock))
+	defer r.Close()
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 2)
+		io.ReadFull(r, buf)
+		got <- string(buf)
+	}()
+
+	// The retry waits an hour on the fake clock.
+	clock.waitTimers(c, 1)
+	c.Check(atomic.LoadInt32(&requests), check.Equals, int32(1))
+	clock.Advance(time.Hour)
+	c.Check(<-got, check.Equals, "ok")
+}
@@ -0,0 +1,371 @@
+//go:build windows
+// +build windows
+
+package nbtee2
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessOutbound        = 0x00000002
+	pipeRejectRemoteClients   = 0x00000008
+	pipeUnlimitedInstances    = 255
+	fileFlagOverlapped        = 0x40000000
+	fileFlagFirstPipeInstance = 0x00080000
+	errorPipeConnected        = syscall.Errno(535)
+	pipeBufferSize            = 64 * 1024
+)
+
+// ListenPipe returns a net.Listener that accepts clients on the
+// Windows named pipe with the given name (e.g., `\\.\pipe\nbtee`),
+// so local consumers can subscribe without TCP by opening the pipe
+// for reading. Use it with Server.Serve, as with a unix socket
+// elsewhere.
+//
+// The pipe is outbound only: clients can't send anything. Its
+// connections support deadlines, so Server.WriteTimeout disconnects
+// clients that stop reading.
+func ListenPipe(name string) (net.Listener, error) {
+	l := &pipeListener{name: name}
+	// Create the first instance now, so the name is taken (and
+	// clients can connect) before Accept is called.
+	h, err := l.create(fileFlagFirstPipeInstance)
+	if err != nil {
+		return nil, err
+	}
+	l.next = h
+	return l, nil
+}
+
+type pipeListener struct {
+	name    string
+	mtx     sync.Mutex
+	next    syscall.Handle // instance to accept on, or 0
+	waiting bool           // Accept is waiting on next
+	closed  bool
+}
+
+func (l *pipeListener) create(flags uint32) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(l.name)
+	if err != nil {
+		return 0, err
+	}
+	r1, _, e1 := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		uintptr(pipeAccessOutbound|fileFlagOverlapped|flags),
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0)
+	if h := syscall.Handle(r1); h != syscall.InvalidHandle {
+		return h, nil
+	}
+	return 0, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(l.name), Err: e1}
+}
+
+// Accept waits for a client to open the pipe.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mtx.Lock()
+	if l.closed {
+		l.mtx.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := l.next
+	l.next = 0
+	if h == 0 {
+		var err error
+		h, err = l.create(0)
+		if err != nil {
+			l.mtx.Unlock()
+			return nil, err
+		}
+	}
+	l.next = h
+	l.waiting = true
+	l.mtx.Unlock()
+
+	err := connectPipe(h)
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.waiting = false
+	l.next = 0
+	if l.closed {
+		syscall.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.name), Err: err}
+	}
+	pc, err := newPipeConn(h, pipeAddr(l.name))
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// Wait for a client to connect to pipe instance h. It can be
+// interrupted with CancelIoEx.
+func connectPipe(h syscall.Handle) error {
+	ev, err := createEvent()
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(ev)
+	ov := &syscall.Overlapped{HEvent: ev}
+	r1, _, e1 := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(ov)))
+	if r1 != 0 || e1 == errorPipeConnected {
+		return nil
+	} else if e1 != syscall.ERROR_IO_PENDING {
+		return e1
+	}
+	_, err = getOverlappedResult(h, ov)
+	return err
+}
+
+// Close stops accepting clients. Connected clients are not affected.
+func (l *pipeListener) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.waiting {
+		// Accept closes the handle when ConnectNamedPipe
+		// returns.
+		syscall.CancelIoEx(l.next, nil)
+	} else if l.next != 0 {
+		syscall.CloseHandle(l.next)
+		l.next = 0
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.name) }
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// A pipeConn is the server end of a connected pipe instance. Writes
+// are overlapped, so a deadline or Close can cancel one that is
+// waiting for the client to read.
+type pipeConn struct {
+	h    syscall.Handle
+	addr pipeAddr
+
+	wmtx sync.Mutex // serializes writes, and Close with writes
+	wov  syscall.Overlapped
+
+	mtx       sync.Mutex
+	wdeadline time.Time
+	wtimer    *time.Timer
+	rdeadline time.Time
+	rchanged  chan struct{} // closed when rdeadline changes
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeConn(h syscall.Handle, addr pipeAddr) (*pipeConn, error) {
+	ev, err := createEvent()
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	return &pipeConn{
+		h:        h,
+		addr:     addr,
+		wov:      syscall.Overlapped{HEvent: ev},
+		rchanged: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Read blocks until the connection is closed or the read deadline
+// passes, since clients can't send anything.
+func (pc *pipeConn) Read(p []byte) (int, error) {
+	for {
+		pc.mtx.Lock()
+		deadline, changed := pc.rdeadline, pc.rchanged
+		pc.mtx.Unlock()
+		if err := pc.waitRead(deadline, changed); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Wait until pc is closed, deadline passes, or changed is closed.
+func (pc *pipeConn) waitRead(deadline time.Time, changed <-chan struct{}) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-pc.closed:
+		return io.EOF
+	case <-changed:
+		return nil
+	case <-expired:
+		return &net.OpError{Op: "read", Net: "pipe", Addr: pc.addr, Err: os.ErrDeadlineExceeded}
+	}
+}
+
+func (pc *pipeConn) Write(p []byte) (int, error) {
+	pc.wmtx.Lock()
+	defer pc.wmtx.Unlock()
+	var n int
+	for n < len(p) {
+		done, err := pc.write(p[n:])
+		n += done
+		if err != nil {
+			return n, &net.OpError{Op: "write", Net: "pipe", Addr: pc.addr, Err: err}
+		}
+	}
+	return n, nil
+}
+
+// Do one overlapped write and wait for it, unless the write deadline
+// passes or pc is closed first. Caller must have pc.wmtx.
+func (pc *pipeConn) write(p []byte) (int, error) {
+	if err := pc.writeErr(); err != nil {
+		return 0, err
+	}
+	var done uint32
+	err := syscall.WriteFile(pc.h, p, &done, &pc.wov)
+	if err == syscall.ERROR_IO_PENDING {
+		// The deadline timer (or Close) might have tried to
+		// cancel the write before it started.
+		if pc.writeErr() != nil {
+			syscall.CancelIoEx(pc.h, &pc.wov)
+		}
+		done, err = getOverlappedResult(pc.h, &pc.wov)
+	}
+	if err == syscall.ERROR_OPERATION_ABORTED {
+		if werr := pc.writeErr(); werr != nil {
+			err = werr
+		}
+	}
+	return int(done), err
+}
+
+// Return the error a write should fail with now, if any.
+func (pc *pipeConn) writeErr() error {
+	select {
+	case <-pc.closed:
+		return net.ErrClosed
+	default:
+	}
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	if !pc.wdeadline.IsZero() && !time.Now().Before(pc.wdeadline) {
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// Cancel a pending write because the write deadline has passed.
+func (pc *pipeConn) cancelWrite() {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	select {
+	case <-pc.closed:
+		// The handle may already be closed.
+	default:
+		syscall.CancelIoEx(pc.h, &pc.wov)
+	}
+}
+
+// Close closes the server end, cancelling a pending write. The client
+// can still read what was written before it gets EOF.
+func (pc *pipeConn) Close() error {
+	var err error
+	pc.closeOnce.Do(func() {
+		pc.mtx.Lock()
+		close(pc.closed)
+		if pc.wtimer != nil {
+			pc.wtimer.Stop()
+		}
+		syscall.CancelIoEx(pc.h, &pc.wov)
+		pc.mtx.Unlock()
+		// Wait for a cancelled write to return before closing
+		// the handle it's using.
+		pc.wmtx.Lock()
+		err = syscall.CloseHandle(pc.h)
+		syscall.CloseHandle(pc.wov.HEvent)
+		pc.wmtx.Unlock()
+	})
+	return err
+}
+
+func (pc *pipeConn) LocalAddr() net.Addr  { return pc.addr }
+func (pc *pipeConn) RemoteAddr() net.Addr { return pc.addr }
+
+func (pc *pipeConn) SetDeadline(t time.Time) error {
+	pc.SetReadDeadline(t)
+	return pc.SetWriteDeadline(t)
+}
+
+func (pc *pipeConn) SetReadDeadline(t time.Time) error {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	pc.rdeadline = t
+	close(pc.rchanged)
+	pc.rchanged = make(chan struct{})
+	return nil
+}
+
+func (pc *pipeConn) SetWriteDeadline(t time.Time) error {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	pc.wdeadline = t
+	if pc.wtimer != nil {
+		pc.wtimer.Stop()
+		pc.wtimer = nil
+	}
+	if !t.IsZero() {
+		pc.wtimer = time.AfterFunc(time.Until(t), pc.cancelWrite)
+	}
+	return nil
+}
+
+// Return a new manual-reset event for an Overlapped.
+func createEvent() (syscall.Handle, error) {
+	r1, _, e1 := procCreateEventW.Call(0, 1, 0, 0)
+	if r1 == 0 {
+		return 0, e1
+	}
+	return syscall.Handle(r1), nil
+}
+
+// Wait for the overlapped operation ov on h to finish.
+func getOverlappedResult(h syscall.Handle, ov *syscall.Overlapped) (uint32, error) {
+	var done uint32
+	r1, _, e1 := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&done)), 1)
+	if r1 == 0 {
+		return done, e1
+	}
+	return done, nil
+}
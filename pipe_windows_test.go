@@ -0,0 +1,84 @@
+//go:build windows
+// +build windows
+
+package nbtee2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestListenPipe(c *check.C) {
+	name := fmt.Sprintf(`\\.\pipe\nbtee2-test-%d`, os.Getpid())
+	l, err := ListenPipe(name)
+	c.Assert(err, check.IsNil)
+	w := &Tee{}
+	done := make(chan error)
+	go func() { done <- (&Server{Tee: w}).Serve(l) }()
+
+	f, err := os.Open(name)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(f, buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+
+	l.Close()
+	c.Check(errors.Is(<-done, net.ErrClosed), check.Equals, true)
+	w.Write([]byte("bar"))
+	w.Close()
+	rest, err := ioutil.ReadAll(f)
+	c.Check(err, check.IsNil)
+	c.Check(string(rest), check.Equals, "bar")
+}
+
+func (s *Suite) TestPipeWriteDeadline(c *check.C) {
+	name := fmt.Sprintf(`\\.\pipe\nbtee2-deadline-%d`, os.Getpid())
+	l, err := ListenPipe(name)
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		c.Check(err, check.IsNil)
+		accepted <- conn
+	}()
+	f, err := os.Open(name)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	conn := <-accepted
+	c.Assert(conn, check.NotNil)
+
+	// The client never reads, so this fills the pipe buffer and
+	// blocks until the deadline.
+	buf := make([]byte, 4*pipeBufferSize)
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Write(buf)
+	c.Check(errors.Is(err, os.ErrDeadlineExceeded), check.Equals, true)
+
+	// Close interrupts a write with no deadline.
+	conn.SetWriteDeadline(time.Time{})
+	done := make(chan error)
+	go func() {
+		_, err := conn.Write(buf)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-done:
+		c.Check(errors.Is(err, net.ErrClosed), check.Equals, true)
+	case <-time.After(5 * time.Second):
+		c.Error("Write did not return after Close")
+	}
+}
@@ -0,0 +1,145 @@
+package nbtee2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// PollHandler is an http.Handler that serves a Tee to long-polling
+// clients, for networks where streaming responses don't work.
+//
+// A client requests "?cursor=N", where N is the cursor returned by
+// its previous request (or omitted, to start with the next write).
+// The response contains every retained write after N, one per line,
+// in the format described at JSONLine. If there are none, the
+// handler waits up to Timeout for a new write. The cursor for the
+// next request is returned in the X-Nbtee-Cursor response header.
+//
+// Only writes in the Tee's retention window (see Tee.Retain) can be
+// returned to a client that isn't waiting when they are written. If a
+// client's cursor is older than the retention window, the first
+// message in the response has Gap set.
+type PollHandler struct {
+	Tee *Tee
+
+	// How long to wait for new data. Default 30s.
+	Timeout time.Duration
+
+	// Maximum number of messages per response. Default 1000.
+	MaxMessages int
+
+	// Send message data as text instead of base64 (see
+	// NewJSONLinesReader).
+	Text bool
+
+	// Authorize, if not nil, is called before each request is
+	// served, as with StreamHandler.
+	Authorize func(*http.Request) error
+}
+
+// ServeHTTP implements http.Handler.
+func (h *PollHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !authorize(w, req, h.Authorize) {
+		return
+	}
+	cursor := int64(-1)
+	if s := req.FormValue("cursor"); s != "" {
+		var err error
+		cursor, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || cursor < 0 {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	max := h.MaxMessages
+	if max <= 0 {
+		max = 1000
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	r := h.Tee.newReaderAfter(ctx, cursor)
+	defer r.Close()
+
+	var msgs []Message
+	if msg, err := r.ReadMessage(); err == nil {
+		msgs = append(msgs, msg)
+		for len(msgs) < max {
+			msg, ok := r.poll()
+			if !ok {
+				break
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	next := r.lastSeq
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Nbtee-Cursor", strconv.FormatUint(next, 10))
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, msg := range msgs {
+		enc.Encode(newJSONLine(msg, h.Text))
+	}
+}
+
+// Return a reader whose first message will be the first retained
+// write after the given cursor (sequence number), or the next write
+// if cursor is negative.
+func (w *Tee) newReaderAfter(ctx context.Context, cursor int64) *Reader {
+	r := w.newReader(ctx)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.Retain > 0 {
+		w.expire(w.now())
+	}
+	w.addReader(r)
+	if cursor < 0 || uint64(cursor) > r.lastSeq {
+		return r
+	}
+	i := len(w.history)
+	for i > 0 && w.history[i-1].seq > uint64(cursor) {
+		i--
+	}
+	r.backlog = append([]*message(nil), w.history[i:]...)
+	r.lastSeq = uint64(cursor)
+	return r
+}
+
+// Return the next message if one is available without waiting.
+// Messages the reader skips (see popBacklog) don't count: poll keeps
+// looking until it finds one to deliver or the channel is empty.
+func (r *Reader) poll() (Message, bool) {
+	if r.discarded() {
+		return Message{}, false
+	}
+	if len(r.todo) > 0 {
+		return r.takeMessage(), true
+	}
+	for {
+		if m := r.popBacklog(); m != nil {
+			r.todo = r.data(m)
+			return r.takeMessage(), true
+		}
+		select {
+		case m, ok := <-r.rch:
+			if !ok {
+				if r.switchChannel() {
+					continue
+				}
+				return Message{}, false
+			}
+			atomic.AddInt64(&r.bufferedBytes, -int64(len(m.buf)))
+			r.backlog = append(r.backlog, m)
+		default:
+			return Message{}, false
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package nbtee2
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestPollHandler(c *check.C) {
+	w := &Tee{Retain: time.Minute}
+	h := &PollHandler{Tee: w, Timeout: 20 * time.Millisecond, MaxMessages: 2, Text: true}
+	poll := func(query string) (cursor string, texts []string, gaps []bool) {
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, httptest.NewRequest("GET", "/"+query, nil))
+		c.Assert(resp.Code, check.Equals, http.StatusOK)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line JSONLine
+			c.Assert(json.Unmarshal(scanner.Bytes(), &line), check.IsNil)
+			texts = append(texts, *line.Text)
+			gaps = append(gaps, line.Gap)
+		}
+		return resp.Header().Get("X-Nbtee-Cursor"), texts, gaps
+	}
+
+	// No data yet: times out, returns current cursor.
+	cursor, texts, _ := poll("")
+	c.Check(cursor, check.Equals, "0")
+	c.Check(texts, check.HasLen, 0)
+
+	w.Write([]byte("a"))
+	w.Write([]byte("b"))
+	w.Write([]byte("c"))
+	cursor, texts, gaps := poll("?cursor=0")
+	c.Check(cursor, check.Equals, "2")
+	c.Check(texts, check.DeepEquals, []string{"a", "b"})
+	c.Check(gaps, check.DeepEquals, []bool{false, false})
+	cursor, texts, _ = poll("?cursor=" + cursor)
+	c.Check(cursor, check.Equals, "3")
+	c.Check(texts, check.DeepEquals, []string{"c"})
+
+	// Wait for a new write.
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("d"))
+	}()
+	h.Timeout = 10 * time.Second
+	cursor, texts, _ = poll("?cursor=" + cursor)
+	c.Check(cursor, check.Equals, "4")
+	c.Check(texts, check.DeepEquals, []string{"d"})
+
+	// Cursor older than the retention window.
+	w.mtx.Lock()
+	w.history[0].t = time.Now().Add(-time.Hour)
+	w.history[1].t = time.Now().Add(-time.Hour)
+	w.mtx.Unlock()
+	cursor, texts, gaps = poll("?cursor=0")
+	c.Check(cursor, check.Equals, "4")
+	c.Check(texts, check.DeepEquals, []string{"c", "d"})
+	c.Check(gaps, check.DeepEquals, []bool{true, false})
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest("GET", "/?cursor=x", nil))
+	c.Check(resp.Code, check.Equals, http.StatusBadRequest)
+	c.Check(w.Stats().Readers, check.HasLen, 0)
+}
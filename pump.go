@@ -0,0 +1,113 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+)
+
+// PumpTo attaches a new reader to w and copies everything it reads
+// to dst. It returns nil when w is closed, ctx.Err() when ctx is
+// cancelled, or the error returned by dst.Write.
+//
+// PumpTo and PumpFrom are meant to be run with something like
+// golang.org/x/sync/errgroup:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error { return nbtee2.PumpFrom(ctx, src, tee) })
+//	g.Go(func() error { return nbtee2.PumpTo(ctx, tee, dst) })
+//	err := g.Wait()
+func PumpTo(ctx context.Context, w *Tee, dst io.Writer, opts ...ReaderOption) error {
+	r := w.newLiveReader(ctx, opts...)
+	_, err := r.WriteTo(dst)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// PumpFrom reads from src and sends the data to w, one write per
+// Read, until src returns EOF (in which case PumpFrom returns nil),
+// src returns some other error, or ctx is cancelled (in which case it
+// returns ctx.Err()). It does not close w.
+//
+// If src is an io.Closer, it is closed when ctx is cancelled, so a
+// blocked Read doesn't keep PumpFrom from returning.
+func PumpFrom(ctx context.Context, src io.Reader, w *Tee) error {
+	if c, ok := src.(io.Closer); ok {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.Close()
+			case <-done:
+			}
+		}()
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if n > 0 {
+			w.Write(buf[:n])
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Connect starts a goroutine that reads each write from src (with a
+// new reader, configured with the given options), passes its data to
+// transform, and sends the result to dst as a single write with the
+// same tag. This builds processing chains out of Tees, e.g., raw
+// input → transcoded → fan-out. If transform is nil, writes are
+// copied unchanged.
+//
+// If transform returns nil, nothing is sent. Otherwise, the returned
+// slice is sent without copying, so it must not be modified
+// afterward; it may be the slice transform was given.
+//
+// If the reader misses writes, the next write sent to dst has a gap
+// (see WriteMessage), so dst's readers know. When src is closed, dst
+// is closed too, so EOF propagates down the chain.
+//
+// The returned function stops the goroutine (without closing dst)
+// and waits for it to exit.
+func Connect(src, dst *Tee, transform func([]byte) []byte, opts ...ReaderOption) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := src.newLiveReader(ctx, opts...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer r.Close()
+		gap := false
+		for {
+			msg, err := r.ReadMessage()
+			if err == io.EOF {
+				dst.Close()
+				return
+			} else if err != nil {
+				return
+			}
+			gap = gap || msg.Gap
+			data := msg.Data
+			if transform != nil {
+				data = transform(data)
+				if data == nil {
+					continue
+				}
+			}
+			dst.send(&message{buf: data, tag: msg.Tag, gap: gap})
+			gap = false
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,100 @@
+package nbtee2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	check "gopkg.in/check.v1"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func (s *Suite) TestPumps(c *check.C) {
+	w := &Tee{}
+	var dst bytes.Buffer
+	done := make(chan error)
+	go func() { done <- PumpTo(context.Background(), w, &dst) }()
+	waitReaders(c, w, 1)
+	c.Check(PumpFrom(context.Background(), strings.NewReader("foobar"), w), check.IsNil)
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	c.Check(dst.String(), check.Equals, "foobar")
+}
+
+func (s *Suite) TestPumpToError(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	done := make(chan error)
+	go func() { done <- PumpTo(context.Background(), w, failWriter{}) }()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	c.Check(<-done, check.ErrorMatches, "write failed")
+	waitReaders(c, w, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- PumpTo(ctx, w, failWriter{}) }()
+	waitReaders(c, w, 1)
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+}
+
+func (s *Suite) TestPumpFromCancel(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- PumpFrom(ctx, pr, w) }()
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+}
+
+func (s *Suite) TestConnect(c *check.C) {
+	src, dst := &Tee{}, &Tee{}
+	r := dst.newLiveReader(context.Background())
+	Connect(src, dst, func(p []byte) []byte {
+		if p[0] == 0 {
+			return nil
+		}
+		return bytes.ToUpper(p)
+	})
+	src.Write([]byte("foo"))
+	src.Write([]byte{0})
+	src.Writer("x").Write([]byte("bar"))
+	src.Close()
+	var got []Message
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			c.Check(err, check.Equals, io.EOF)
+			break
+		}
+		got = append(got, msg)
+	}
+	c.Assert(got, check.HasLen, 2)
+	c.Check(string(got[0].Data), check.Equals, "FOO")
+	c.Check(string(got[1].Data), check.Equals, "BAR")
+	c.Check(got[1].Tag, check.Equals, "x")
+}
+
+func (s *Suite) TestConnectStop(c *check.C) {
+	src, dst := &Tee{}, &Tee{}
+	stop := Connect(src, dst, nil)
+	c.Check(src.Stats().Readers, check.HasLen, 1)
+	stop()
+	c.Check(src.Stats().Readers, check.HasLen, 0)
+	r := dst.newLiveReader(context.Background())
+	src.Write([]byte("foo"))
+	dst.Write([]byte("bar"))
+	dst.Close()
+	buf, err := ioutil.ReadAll(r)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "bar")
+}
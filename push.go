@@ -0,0 +1,114 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// A PushSink streams everything sent to a Tee to a remote endpoint
+// that it connects to itself, reconnecting after failures.
+//
+// This is how to feed an ingest endpoint in "caller" mode with a
+// protocol this package doesn't implement, such as SRT: Dial can
+// return a connection from an SRT library, with MaxWrite set to the
+// live-mode payload size if the library doesn't split writes itself.
+// (For "listener" mode, pass the library's net.Listener to
+// Server.Serve, or its accepted connections to ConnSet.Add.) The
+// nbteesrt module does both for SRT.
+type PushSink struct {
+	Tee *Tee
+
+	// Dial connects to the remote endpoint.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Maximum time a single write may take. Zero means no limit.
+	WriteTimeout time.Duration
+
+	// Maximum size of each write to the connection, e.g., 1316 for
+	// MPEG-TS over SRT in live mode. Larger writes are split. Zero
+	// means no limit.
+	MaxWrite int
+
+	// Options for each connection's reader.
+	ReaderOptions []ReaderOption
+
+	// Delay before reconnecting after a failure. The first delay is
+	// MinBackoff, and each consecutive failure doubles it, up to
+	// MaxBackoff. Defaults 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Disconnected, if not nil, is called when Dial fails (with the
+	// error in Err) and with each connection's session stats after
+	// it is closed.
+	Disconnected func(ConnStats)
+}
+
+// Run connects and streams until ctx is cancelled (returning
+// ctx.Err()) or the Tee is closed while connected (returning nil).
+func (ps *PushSink) Run(ctx context.Context) error {
+	minBackoff, maxBackoff := ps.MinBackoff, ps.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = 100 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+	var ss sessions
+	backoff := minBackoff
+	for {
+		var st ConnStats
+		conn, err := ps.Dial(ctx)
+		if err != nil {
+			st = ConnStats{Start: ps.Tee.now(), Err: err}
+		} else {
+			if ps.MaxWrite > 0 {
+				conn = chunkConn{conn, ps.MaxWrite}
+			}
+			st = streamToConn(ctx, ps.Tee, conn, ps.WriteTimeout, ps.ReaderOptions, &ss)
+		}
+		if ps.Disconnected != nil {
+			ps.Disconnected(st)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if st.Err == io.EOF {
+			return nil
+		}
+		if st.Bytes > 0 {
+			backoff = minBackoff
+		}
+		if err := ps.Tee.sleep(ctx, backoff); err != nil {
+			return err
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// A chunkConn splits each write into writes of at most max bytes.
+type chunkConn struct {
+	net.Conn
+	max int
+}
+
+func (cc chunkConn) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > cc.max {
+			chunk = chunk[:cc.max]
+		}
+		nn, err := cc.Conn.Write(chunk)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
@@ -0,0 +1,78 @@
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestPushSink(c *check.C) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer ln.Close()
+
+	w := &Tee{}
+	dials := 0
+	sizes := make(chan int, 10)
+	var stats []ConnStats
+	ps := &PushSink{
+		Tee: w,
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			dials++
+			if dials == 1 {
+				return nil, errors.New("refused")
+			}
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			return sizeLogConn{conn, sizes}, err
+		},
+		MaxWrite:     3,
+		MinBackoff:   time.Millisecond,
+		Disconnected: func(st ConnStats) { stats = append(stats, st) },
+	}
+	done := make(chan error)
+	go func() { done <- ps.Run(context.Background()) }()
+	conn, err := ln.Accept()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("abcdefgh"))
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(conn, buf)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "abcdefgh")
+	c.Check([]int{<-sizes, <-sizes, <-sizes}, check.DeepEquals, []int{3, 3, 2})
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	c.Check(dials, check.Equals, 2)
+	c.Assert(stats, check.HasLen, 2)
+	c.Check(stats[0].Err, check.ErrorMatches, "refused")
+	c.Check(stats[1].Err, check.Equals, io.EOF)
+	c.Check(stats[1].Bytes, check.Equals, int64(8))
+}
+
+func (s *Suite) TestPushSinkCancel(c *check.C) {
+	ps := &PushSink{
+		Tee: &Tee{},
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			return nil, errors.New("refused")
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Check(ps.Run(ctx), check.Equals, context.DeadlineExceeded)
+}
+
+// A sizeLogConn sends the size of each write to a channel.
+type sizeLogConn struct {
+	net.Conn
+	sizes chan<- int
+}
+
+func (sc sizeLogConn) Write(p []byte) (int, error) {
+	sc.sizes <- len(p)
+	return sc.Conn.Write(p)
+}
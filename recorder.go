@@ -0,0 +1,140 @@
+package nbtee2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// A Recorder copies everything sent to a Tee into a sequence of
+// files, starting a new file when the current one reaches MaxBytes
+// or has been open for MaxAge. Files are only split between writes.
+type Recorder struct {
+	Tee *Tee
+
+	// Filename is a text/template that produces the path of each
+	// new file. It is executed with a struct having fields Time
+	// (time.Time, when the file is opened) and Seq (int, 0 for the
+	// first file). Missing directories are created.
+	//
+	// Example: "rec/{{.Time.Format \"20060102-150405\"}}-{{.Seq}}.bin"
+	Filename string
+
+	// Start a new file before a write that would make the current
+	// file larger than MaxBytes. Zero means no limit.
+	MaxBytes int64
+
+	// Start a new file before a write that arrives MaxAge or more
+	// after the current file was opened. Zero means no limit.
+	MaxAge time.Duration
+
+	// Buffer size of the recorder's reader, in writes. If the disk
+	// falls this far behind, data is dropped, just as with any
+	// other reader. Zero means 1024.
+	HighWater int
+
+	tmpl *template.Template
+	f    *os.File
+	size int64
+	open time.Time
+	seq  int
+}
+
+// Run attaches a reader to rec.Tee and records until the Tee is
+// closed (in which case it returns nil), ctx is cancelled, or an
+// error occurs.
+func (rec *Recorder) Run(ctx context.Context) error {
+	tmpl, err := template.New("filename").Parse(rec.Filename)
+	if err != nil {
+		return err
+	}
+	rec.tmpl = tmpl
+	highwater := rec.HighWater
+	if highwater == 0 {
+		highwater = 1024
+	}
+	r := rec.Tee.newLiveReader(ctx, WithWatermarks(0, highwater))
+	defer r.Close()
+
+	err = rec.run(r)
+	if cerr := rec.closeFile(); err == nil {
+		err = cerr
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+func (rec *Recorder) run(r *Reader) error {
+	for {
+		err := r.fillTodo()
+		if len(r.todo) > 0 {
+			if err := rec.write(r.todo); err != nil {
+				return err
+			}
+			r.todo = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (rec *Recorder) write(p []byte) error {
+	if rec.f != nil && ((rec.MaxBytes > 0 && rec.size > 0 && rec.size+int64(len(p)) > rec.MaxBytes) ||
+		(rec.MaxAge > 0 && rec.Tee.now().Sub(rec.open) >= rec.MaxAge)) {
+		if err := rec.closeFile(); err != nil {
+			return err
+		}
+	}
+	if rec.f == nil {
+		if err := rec.openFile(); err != nil {
+			return err
+		}
+	}
+	n, err := rec.f.Write(p)
+	rec.size += int64(n)
+	return err
+}
+
+func (rec *Recorder) openFile() error {
+	now := rec.Tee.now()
+	f, err := createFromTemplate(rec.tmpl, now, rec.seq)
+	if err != nil {
+		return err
+	}
+	rec.f, rec.size, rec.open = f, 0, now
+	rec.seq++
+	return nil
+}
+
+// Create (or append to) the file whose name is given by executing
+// tmpl with the given time and sequence number.
+func createFromTemplate(tmpl *template.Template, t time.Time, seq int) (*os.File, error) {
+	var name bytes.Buffer
+	err := tmpl.Execute(&name, struct {
+		Time time.Time
+		Seq  int
+	}{t, seq})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name.String()), 0777); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(name.String(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+}
+
+func (rec *Recorder) closeFile() error {
+	if rec.f == nil {
+		return nil
+	}
+	err := rec.f.Close()
+	rec.f = nil
+	return err
+}
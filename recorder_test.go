@@ -0,0 +1,77 @@
+package nbtee2
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+// Wait for n readers to be attached to w.
+func waitReaders(c *check.C, w *Tee, n int) {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		w.mtx.Lock()
+		ok := len(w.readers) == n
+		w.mtx.Unlock()
+		if ok {
+			return
+		}
+	}
+	c.Fatalf("timed out waiting for %d readers", n)
+}
+
+func (s *Suite) TestRecorderRotateBySize(c *check.C) {
+	dir := c.MkDir()
+	w := &Tee{}
+	rec := &Recorder{
+		Tee:      w,
+		Filename: filepath.Join(dir, "sub", "rec-{{.Seq}}.bin"),
+		MaxBytes: 5,
+	}
+	done := make(chan error)
+	go func() { done <- rec.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	w.Write([]byte("abc"))
+	w.Write([]byte("de"))
+	w.Write([]byte("fgh"))
+	w.Write([]byte("ijklmnop"))
+	w.Close()
+	c.Check(<-done, check.IsNil)
+
+	for seq, expect := range []string{"abcde", "fgh", "ijklmnop"} {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "sub", fmt.Sprintf("rec-%d.bin", seq)))
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, expect)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "sub", "*"))
+	c.Check(files, check.HasLen, 3)
+}
+
+func (s *Suite) TestRecorderRotateByAge(c *check.C) {
+	dir := c.MkDir()
+	w := &Tee{}
+	rec := &Recorder{
+		Tee:      w,
+		Filename: filepath.Join(dir, "rec-{{.Seq}}.bin"),
+		MaxAge:   time.Millisecond,
+	}
+	done := make(chan error)
+	go func() { done <- rec.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	w.Write([]byte("abc"))
+	time.Sleep(10 * time.Millisecond)
+	w.Write([]byte("def"))
+	w.Close()
+	c.Check(<-done, check.IsNil)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	c.Check(files, check.HasLen, 2)
+}
+
+func (s *Suite) TestRecorderBadTemplate(c *check.C) {
+	rec := &Recorder{Tee: &Tee{}, Filename: "{{.Nope"}
+	c.Check(rec.Run(context.Background()), check.NotNil)
+}
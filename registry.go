@@ -0,0 +1,134 @@
+package nbtee2
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Registry is a set of named Tees, created on demand and closed
+// after they have been idle for IdleTTL. This is the usual way for a
+// server to manage multiple channels.
+//
+// The zero value is an empty registry whose Tees are never removed.
+type Registry struct {
+	// How long a Tee can have no readers and no writes before it
+	// is closed and removed. Zero means forever. Idle Tees are
+	// only removed by Collect, which is called periodically by Run.
+	IdleTTL time.Duration
+
+	// New, if not nil, is called to create each new Tee, e.g., to
+	// set its retention window. Otherwise, new Tees are zero
+	// values.
+	New func(name string) *Tee
+
+	// Clock that Run waits on. Idle times are measured with each
+	// Tee's own clock (see WithClock). Nil means the system clock.
+	Clock Clock
+
+	tees map[string]*Tee
+	mtx  sync.Mutex
+}
+
+// Get returns the named Tee, creating it if needed. Get counts as
+// activity, so the returned Tee won't be removed for at least
+// IdleTTL even if it has no readers or writes yet.
+func (reg *Registry) Get(name string) *Tee {
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	w, ok := reg.tees[name]
+	if !ok {
+		if reg.New != nil {
+			w = reg.New(name)
+		} else {
+			w = &Tee{}
+		}
+		if reg.tees == nil {
+			reg.tees = map[string]*Tee{}
+		}
+		reg.tees[name] = w
+	}
+	w.mtx.Lock()
+	w.active = w.now()
+	w.mtx.Unlock()
+	return w
+}
+
+// Lookup returns the named Tee, or nil if it doesn't exist. Unlike
+// Get, Lookup does not create a Tee or count as activity.
+func (reg *Registry) Lookup(name string) *Tee {
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	return reg.tees[name]
+}
+
+// Names returns the names of all Tees in the registry, sorted.
+func (reg *Registry) Names() []string {
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	names := make([]string, 0, len(reg.tees))
+	for name := range reg.tees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tees returns a copy of the registry's name-to-Tee map. It can be
+// used as DebugHandler.Tees.
+func (reg *Registry) Tees() map[string]*Tee {
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	tees := make(map[string]*Tee, len(reg.tees))
+	for name, w := range reg.tees {
+		tees[name] = w
+	}
+	return tees
+}
+
+// Collect closes and removes all Tees that have been idle for
+// IdleTTL or longer, and returns their names.
+func (reg *Registry) Collect() []string {
+	if reg.IdleTTL <= 0 {
+		return nil
+	}
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	var removed []string
+	for name, w := range reg.tees {
+		if w.idle(w.now()) >= reg.IdleTTL {
+			w.Close()
+			delete(reg.tees, name)
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Run calls Collect periodically (every IdleTTL/2) until ctx is
+// cancelled, then returns ctx.Err().
+func (reg *Registry) Run(ctx context.Context) error {
+	if reg.IdleTTL <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	for {
+		if err := clockSleep(ctx, reg.Clock, reg.IdleTTL/2); err != nil {
+			return err
+		}
+		reg.Collect()
+	}
+}
+
+// Return how long w has had no readers and no activity, or 0 if it
+// has readers.
+func (w *Tee) idle(now time.Time) time.Duration {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if len(w.readers) > 0 {
+		return 0
+	}
+	return now.Sub(w.active)
+}
@@ -0,0 +1,35 @@
+package nbtee2
+
+import (
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestRegistry(c *check.C) {
+	reg := &Registry{
+		IdleTTL: 20 * time.Millisecond,
+		New:     func(name string) *Tee { return &Tee{Retain: time.Second} },
+	}
+	a := reg.Get("a")
+	c.Check(a.Retain, check.Equals, time.Second)
+	c.Check(reg.Get("a"), check.Equals, a)
+	b := reg.Get("b")
+	c.Check(reg.Lookup("c"), check.IsNil)
+	c.Check(reg.Names(), check.DeepEquals, []string{"a", "b"})
+	c.Check(reg.Tees(), check.DeepEquals, map[string]*Tee{"a": a, "b": b})
+	c.Check(reg.Collect(), check.HasLen, 0)
+
+	r := a.NewReader(0, 1)
+	time.Sleep(30 * time.Millisecond)
+	c.Check(reg.Collect(), check.DeepEquals, []string{"b"})
+	c.Check(reg.Names(), check.DeepEquals, []string{"a"})
+
+	// a has been idle since r was closed
+	r.Close()
+	c.Check(reg.Collect(), check.HasLen, 0)
+	time.Sleep(30 * time.Millisecond)
+	c.Check(reg.Collect(), check.DeepEquals, []string{"a"})
+	c.Check(reg.Lookup("a"), check.IsNil)
+	c.Check(reg.Get("a"), check.Not(check.Equals), a)
+}
@@ -0,0 +1,141 @@
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+// RTPHeader is the fixed part of an RTP packet header (RFC 3550).
+// CSRCs and header extensions are not supported.
+type RTPHeader struct {
+	Marker      bool
+	PayloadType uint8 // 0-127
+	Sequence    uint16
+	Timestamp   uint32
+	SSRC        uint32
+}
+
+// AppendTo appends the 12-byte encoding of h to buf.
+func (h RTPHeader) AppendTo(buf []byte) []byte {
+	b1 := h.PayloadType & 0x7f
+	if h.Marker {
+		b1 |= 0x80
+	}
+	buf = append(buf, 2<<6, b1)
+	buf = append(buf, byte(h.Sequence>>8), byte(h.Sequence))
+	var tmp [8]byte
+	binary.BigEndian.PutUint32(tmp[:], h.Timestamp)
+	binary.BigEndian.PutUint32(tmp[4:], h.SSRC)
+	return append(buf, tmp[:]...)
+}
+
+// An RTPSink sends everything written to a Tee as RTP packets, e.g.,
+// over UDP to an RTP receiver or SFU.
+//
+// Each write becomes one or more packets with the same timestamp;
+// the marker bit is set on the last packet of each write. Sequence
+// numbers and the timestamp offset start at random values, as
+// recommended by RFC 3550.
+type RTPSink struct {
+	Tee *Tee
+
+	// Each Write call sends one packet, e.g., a *net.UDPConn
+	// returned by net.DialUDP.
+	Conn io.Writer
+
+	PayloadType uint8
+	SSRC        uint32
+
+	// RTP clock rate, used to derive each packet's timestamp from
+	// the time of the write. Default 90000.
+	ClockRate uint32
+
+	// Maximum payload size per packet. Larger writes are split
+	// into multiple packets. Default 1200.
+	MaxPayload int
+
+	// Header, if not nil, is called before each packet is sent,
+	// and can modify its header, e.g., to derive the timestamp
+	// from the payload or to apply a codec's marker bit rules.
+	// final is true for the last packet of a write. Sequence
+	// numbers continue from the value Header leaves.
+	Header func(h *RTPHeader, msg Message, final bool)
+
+	// Buffer size of the sink's reader, in writes. Zero means 64.
+	HighWater int
+}
+
+// Run attaches a reader to s.Tee and sends packets until the Tee is
+// closed (in which case it returns nil), ctx is cancelled, or a
+// write to s.Conn fails. "Connection refused" errors, which UDP
+// sockets report when nobody is listening yet, are ignored.
+func (s *RTPSink) Run(ctx context.Context) error {
+	clockRate := s.ClockRate
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+	maxPayload := s.MaxPayload
+	if maxPayload <= 0 {
+		maxPayload = 1200
+	}
+	highwater := s.HighWater
+	if highwater == 0 {
+		highwater = defaultHighwater
+	}
+	r := s.Tee.newLiveReader(ctx, WithWatermarks(0, highwater))
+	defer r.Close()
+
+	hdr := RTPHeader{
+		PayloadType: s.PayloadType,
+		SSRC:        s.SSRC,
+		Sequence:    uint16(rand.Uint32()),
+	}
+	tsOffset := rand.Uint32()
+	var t0 time.Time
+	pkt := make([]byte, 0, 12+maxPayload)
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if t0.IsZero() {
+			t0 = msg.Time
+		}
+		elapsed := msg.Time.Sub(t0)
+		ts := tsOffset + rtpTicks(elapsed, clockRate)
+		data := msg.Data
+		for first := true; first || len(data) > 0; first = false {
+			n := len(data)
+			if n > maxPayload {
+				n = maxPayload
+			}
+			final := n == len(data)
+			hdr.Timestamp = ts
+			hdr.Marker = final
+			if s.Header != nil {
+				s.Header(&hdr, msg, final)
+			}
+			pkt = append(hdr.AppendTo(pkt[:0]), data[:n]...)
+			data = data[n:]
+			hdr.Sequence++
+			if _, err := s.Conn.Write(pkt); err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+				return err
+			}
+		}
+	}
+}
+
+// Return elapsed in units of 1/rate seconds, wrapped mod 2^32 as RTP
+// timestamps are. Whole seconds and the remainder are scaled
+// separately so the product doesn't overflow for long streams.
+func rtpTicks(elapsed time.Duration, rate uint32) uint32 {
+	secs, frac := uint64(elapsed/time.Second), uint64(elapsed%time.Second)
+	return uint32(secs*uint64(rate) + frac*uint64(rate)/uint64(time.Second))
+}
@@ -0,0 +1,112 @@
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestRTPSink(c *check.C) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer pc.Close()
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+
+	w := &Tee{}
+	sink := &RTPSink{
+		Tee:         w,
+		Conn:        conn,
+		PayloadType: 96,
+		SSRC:        0x12345678,
+		ClockRate:   1000,
+		MaxPayload:  4,
+	}
+	done := make(chan error)
+	go func() { done <- sink.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	w.Write([]byte("abcdefghij"))
+	w.Write([]byte("xyz"))
+
+	type packet struct {
+		marker  bool
+		pt      uint8
+		seq     uint16
+		ts      uint32
+		ssrc    uint32
+		payload string
+	}
+	var pkts []packet
+	buf := make([]byte, 1500)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(pkts) < 4 {
+		n, _, err := pc.ReadFrom(buf)
+		c.Assert(err, check.IsNil)
+		c.Assert(n >= 12, check.Equals, true)
+		c.Check(buf[0], check.Equals, byte(0x80))
+		pkts = append(pkts, packet{
+			marker:  buf[1]&0x80 != 0,
+			pt:      buf[1] & 0x7f,
+			seq:     binary.BigEndian.Uint16(buf[2:]),
+			ts:      binary.BigEndian.Uint32(buf[4:]),
+			ssrc:    binary.BigEndian.Uint32(buf[8:]),
+			payload: string(buf[12:n]),
+		})
+	}
+	w.Close()
+	c.Check(<-done, check.IsNil)
+
+	for i, expect := range []struct {
+		marker  bool
+		payload string
+	}{{false, "abcd"}, {false, "efgh"}, {true, "ij"}, {true, "xyz"}} {
+		c.Check(pkts[i].marker, check.Equals, expect.marker)
+		c.Check(pkts[i].payload, check.Equals, expect.payload)
+		c.Check(pkts[i].pt, check.Equals, uint8(96))
+		c.Check(pkts[i].ssrc, check.Equals, uint32(0x12345678))
+		c.Check(pkts[i].seq, check.Equals, pkts[0].seq+uint16(i))
+	}
+	c.Check(pkts[1].ts, check.Equals, pkts[0].ts)
+	c.Check(pkts[2].ts, check.Equals, pkts[0].ts)
+}
+
+func (s *Suite) TestRTPTicks(c *check.C) {
+	c.Check(rtpTicks(1500*time.Millisecond, 90000), check.Equals, uint32(135000))
+	// 60h at 90 kHz is more than 2^32 ticks, and elapsed*rate is
+	// more than 2^64.
+	c.Check(rtpTicks(60*time.Hour, 90000), check.Equals, uint32(60*3600*90000%(1<<32)))
+	c.Check(rtpTicks(60*time.Hour+time.Millisecond, 90000), check.Equals, uint32((60*3600*90000+90)%(1<<32)))
+}
+
+func (s *Suite) TestRTPHeaderHook(c *check.C) {
+	w := &Tee{}
+	var sent [][]byte
+	sink := &RTPSink{
+		Tee:  w,
+		Conn: writerFunc(func(p []byte) (int, error) { sent = append(sent, append([]byte(nil), p...)); return len(p), nil }),
+		Header: func(h *RTPHeader, msg Message, final bool) {
+			h.Timestamp = uint32(msg.Seq) * 3000
+			h.Sequence = uint16(msg.Seq) * 10
+			h.Marker = false
+		},
+	}
+	done := make(chan error)
+	go func() { done <- sink.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	w.Write([]byte("a"))
+	w.Write([]byte("b"))
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	c.Assert(sent, check.HasLen, 2)
+	c.Check(binary.BigEndian.Uint16(sent[1][2:]), check.Equals, uint16(20))
+	c.Check(binary.BigEndian.Uint32(sent[1][4:]), check.Equals, uint32(6000))
+	c.Check(sent[1][1]&0x80, check.Equals, byte(0))
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,229 @@
+package nbtee2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"text/template"
+	"time"
+)
+
+// A Segment describes a completed segment.
+type Segment struct {
+	Path  string // empty if the segment was only kept in memory
+	Seq   int
+	Start time.Time // when the first write in the segment arrived
+	End   time.Time // when the segment was completed
+	Size  int64     // bytes
+	Count int       // writes
+}
+
+// A Sink receives completed segments from a SegmentRecorder, e.g.,
+// to ship them to object storage or another host. WriteSegment
+// should read r to EOF before returning.
+type Sink interface {
+	WriteSegment(ctx context.Context, meta Segment, r io.Reader) error
+}
+
+// SinkFunc is an adapter that allows an ordinary function to be used
+// as a Sink.
+type SinkFunc func(ctx context.Context, meta Segment, r io.Reader) error
+
+// WriteSegment implements Sink by calling f.
+func (f SinkFunc) WriteSegment(ctx context.Context, meta Segment, r io.Reader) error {
+	return f(ctx, meta, r)
+}
+
+// A SegmentRecorder cuts the stream sent to a Tee into discrete
+// segment files. A new segment starts at the first keyframe that
+// arrives Duration or more after the current segment started, so
+// each segment starts with a keyframe and (except perhaps the last)
+// is at least Duration long.
+type SegmentRecorder struct {
+	Tee *Tee
+
+	// Filename is a text/template that produces the path of each
+	// segment file, as with Recorder.Filename. If Filename is
+	// empty, segments are accumulated in memory and only passed to
+	// Sink.
+	Filename string
+
+	// Target segment length.
+	Duration time.Duration
+
+	// Keyframe reports whether a segment can start with the given
+	// write. Writes that arrive before the first keyframe are
+	// discarded. If Keyframe is nil, every write is a keyframe.
+	Keyframe func([]byte) bool
+
+	// Sink, if not nil, receives each completed segment, including
+	// the final (possibly short) segment when recording stops. It
+	// is called from the recording goroutine, so a slow sink can
+	// cause the recorder's reader to drop data. If it returns an
+	// error, recording stops and Run returns that error.
+	//
+	// If recording stops because Run's ctx was cancelled, the
+	// final segment is delivered with a context that isn't
+	// cancelled, but times out after FinalTimeout.
+	Sink Sink
+
+	// Time limit for delivering the final segment after Run's ctx
+	// is cancelled. Default 30s.
+	FinalTimeout time.Duration
+
+	// Completed, if not nil, is called after each segment is
+	// closed and (if Sink is not nil) delivered to Sink.
+	Completed func(Segment)
+
+	// Buffer size of the recorder's reader, in writes. Zero means
+	// 1024.
+	HighWater int
+
+	ctx  context.Context
+	tmpl *template.Template
+	f    *os.File
+	mem  *bytes.Buffer
+	out  io.Writer
+	seg  Segment
+}
+
+// Run attaches a reader to sr.Tee and records segments until the
+// Tee is closed (in which case it returns nil), ctx is cancelled, or
+// an error occurs.
+func (sr *SegmentRecorder) Run(ctx context.Context) error {
+	if sr.Filename != "" {
+		tmpl, err := template.New("filename").Parse(sr.Filename)
+		if err != nil {
+			return err
+		}
+		sr.tmpl = tmpl
+	}
+	sr.ctx = ctx
+	highwater := sr.HighWater
+	if highwater == 0 {
+		highwater = 1024
+	}
+	r := sr.Tee.newLiveReader(ctx, WithWatermarks(0, highwater))
+	defer r.Close()
+
+	err := sr.run(r)
+	if ctx.Err() != nil {
+		timeout := sr.FinalTimeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		var cancel context.CancelFunc
+		sr.ctx, cancel = context.WithTimeout(detachedContext{ctx}, timeout)
+		defer cancel()
+	}
+	if cerr := sr.finish(); cerr != nil && (err == nil || err == io.EOF || err == ctx.Err()) {
+		// Report a failure to deliver the final segment
+		// rather than the reason recording stopped.
+		err = cerr
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// A detachedContext has its parent's values, but is never cancelled.
+type detachedContext struct{ context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (sr *SegmentRecorder) run(r *Reader) error {
+	for {
+		err := r.fillTodo()
+		if len(r.todo) > 0 {
+			if err := sr.write(r.todo); err != nil {
+				return err
+			}
+			r.todo = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sr *SegmentRecorder) write(p []byte) error {
+	now := sr.Tee.now()
+	if sr.Keyframe == nil || sr.Keyframe(p) {
+		if sr.out != nil && now.Sub(sr.seg.Start) >= sr.Duration {
+			if err := sr.finish(); err != nil {
+				return err
+			}
+		}
+		if sr.out == nil {
+			if err := sr.start(now); err != nil {
+				return err
+			}
+		}
+	}
+	if sr.out == nil {
+		return nil
+	}
+	n, err := sr.out.Write(p)
+	sr.seg.Size += int64(n)
+	sr.seg.Count++
+	return err
+}
+
+// Start a new segment.
+func (sr *SegmentRecorder) start(now time.Time) error {
+	sr.seg = Segment{Seq: sr.seg.Seq, Start: now}
+	if sr.tmpl == nil {
+		sr.mem = &bytes.Buffer{}
+		sr.out = sr.mem
+		return nil
+	}
+	f, err := createFromTemplate(sr.tmpl, now, sr.seg.Seq)
+	if err != nil {
+		return err
+	}
+	sr.f, sr.out = f, f
+	sr.seg.Path = f.Name()
+	return nil
+}
+
+// Close the current segment, if any, and deliver it.
+func (sr *SegmentRecorder) finish() error {
+	if sr.out == nil {
+		return nil
+	}
+	sr.out = nil
+	sr.seg.End = sr.Tee.now()
+	seg := sr.seg
+	sr.seg = Segment{Seq: seg.Seq + 1}
+	if sr.f != nil {
+		err := sr.f.Close()
+		sr.f = nil
+		if err != nil {
+			return err
+		}
+	}
+	if sr.Sink != nil {
+		var err error
+		if sr.mem != nil {
+			err = sr.Sink.WriteSegment(sr.ctx, seg, sr.mem)
+			sr.mem = nil
+		} else if f, oerr := os.Open(seg.Path); oerr != nil {
+			err = oerr
+		} else {
+			err = sr.Sink.WriteSegment(sr.ctx, seg, f)
+			f.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	sr.mem = nil
+	if sr.Completed != nil {
+		sr.Completed(seg)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestSegmentRecorder(c *check.C) {
+	dir := c.MkDir()
+	w := &Tee{}
+	var segs []Segment
+	sr := &SegmentRecorder{
+		Tee:       w,
+		Filename:  filepath.Join(dir, "seg-{{.Seq}}.ts"),
+		Keyframe:  func(p []byte) bool { return p[0] == 'K' },
+		Completed: func(seg Segment) { segs = append(segs, seg) },
+	}
+	done := make(chan error)
+	go func() { done <- sr.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	for _, p := range []string{"x", "K1", "a", "K2", "b", "c", "K3"} {
+		w.Write([]byte(p))
+	}
+	w.Close()
+	c.Check(<-done, check.IsNil)
+
+	c.Assert(segs, check.HasLen, 3)
+	for i, expect := range []string{"K1a", "K2bc", "K3"} {
+		c.Check(segs[i].Seq, check.Equals, i)
+		c.Check(segs[i].Size, check.Equals, int64(len(expect)))
+		c.Check(segs[i].End.Before(segs[i].Start), check.Equals, false)
+		buf, err := ioutil.ReadFile(segs[i].Path)
+		c.Check(err, check.IsNil)
+		c.Check(string(buf), check.Equals, expect)
+	}
+	c.Check(segs[1].Count, check.Equals, 3)
+}
+
+func (s *Suite) TestSegmentRecorderSink(c *check.C) {
+	w := &Tee{}
+	var got []string
+	var seqs []int
+	sr := &SegmentRecorder{
+		Tee:      w,
+		Keyframe: func(p []byte) bool { return p[0] == 'K' },
+		Sink: SinkFunc(func(ctx context.Context, meta Segment, r io.Reader) error {
+			buf, err := ioutil.ReadAll(r)
+			c.Check(meta.Path, check.Equals, "")
+			c.Check(meta.Size, check.Equals, int64(len(buf)))
+			got = append(got, string(buf))
+			seqs = append(seqs, meta.Seq)
+			return err
+		}),
+	}
+	done := make(chan error)
+	go func() { done <- sr.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	for _, p := range []string{"K1", "a", "K2", "b"} {
+		w.Write([]byte(p))
+	}
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	c.Check(got, check.DeepEquals, []string{"K1a", "K2b"})
+	c.Check(seqs, check.DeepEquals, []int{0, 1})
+}
+
+func (s *Suite) TestSegmentRecorderSinkError(c *check.C) {
+	w := &Tee{}
+	sr := &SegmentRecorder{
+		Tee: w,
+		Sink: SinkFunc(func(context.Context, Segment, io.Reader) error {
+			return errors.New("sink failed")
+		}),
+	}
+	done := make(chan error)
+	go func() { done <- sr.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	w.Write([]byte("a"))
+	w.Write([]byte("b"))
+	c.Check(<-done, check.ErrorMatches, "sink failed")
+	w.Close()
+}
+
+func (s *Suite) TestSegmentRecorderCancel(c *check.C) {
+	for _, sinkErr := range []error{nil, errors.New("upload failed")} {
+		w := &Tee{}
+		sawA := make(chan struct{})
+		var got []string
+		sr := &SegmentRecorder{
+			Tee: w,
+			Keyframe: func(p []byte) bool {
+				if string(p) == "a" {
+					close(sawA)
+				}
+				return p[0] == 'K'
+			},
+			Sink: SinkFunc(func(ctx context.Context, meta Segment, r io.Reader) error {
+				c.Check(ctx.Err(), check.IsNil)
+				_, ok := ctx.Deadline()
+				c.Check(ok, check.Equals, true)
+				buf, _ := ioutil.ReadAll(r)
+				got = append(got, string(buf))
+				return sinkErr
+			}),
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- sr.Run(ctx) }()
+		waitReaders(c, w, 1)
+		w.Write([]byte("K1"))
+		w.Write([]byte("a"))
+		<-sawA
+		cancel()
+		if sinkErr == nil {
+			c.Check(<-done, check.Equals, context.Canceled)
+		} else {
+			c.Check(<-done, check.Equals, sinkErr)
+		}
+		c.Check(got, check.DeepEquals, []string{"K1a"})
+		w.Close()
+	}
+}
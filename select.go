@@ -0,0 +1,50 @@
+package nbtee2
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+)
+
+// Select waits until at least one of the given readers has data (or
+// an error, such as EOF) ready, so its next Read, ReadMessage, or
+// similar call won't block. It returns the index of a ready reader,
+// or ctx.Err() if ctx is cancelled first.
+//
+// This allows a single goroutine to service many low-traffic
+// readers. Select must not be called concurrently with other methods
+// on the same readers (except Close).
+func Select(ctx context.Context, readers ...*Reader) (int, error) {
+	for i, r := range readers {
+		if len(r.todo) > 0 || len(r.backlog) > 0 {
+			return i, nil
+		}
+	}
+	for {
+		cases := make([]reflect.SelectCase, 0, 2*len(readers)+1)
+		for _, r := range readers {
+			cases = append(cases,
+				reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.rch)},
+				reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.ctx.Done())})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+		chosen, v, ok := reflect.Select(cases)
+		if chosen == len(cases)-1 {
+			return -1, ctx.Err()
+		}
+		i := chosen / 2
+		r := readers[i]
+		if chosen%2 == 0 && !ok && r.switchChannel() {
+			// The channel was replaced (see SetHighWater), so
+			// the reader isn't at EOF. Wait on the new one.
+			continue
+		}
+		if chosen%2 == 0 && ok {
+			// Keep the message for the reader's next read.
+			m := v.Interface().(*message)
+			atomic.AddInt64(&r.bufferedBytes, -int64(len(m.buf)))
+			r.backlog = append(r.backlog, m)
+		}
+		return i, nil
+	}
+}
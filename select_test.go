@@ -0,0 +1,73 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestSelect(c *check.C) {
+	tees := []*Tee{{}, {}, {}}
+	var readers []*Reader
+	for _, w := range tees {
+		readers = append(readers, w.NewReader(0, 4))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := Select(ctx, readers...)
+	c.Check(err, check.Equals, context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		tees[1].Write([]byte{1})
+		tees[1].Write([]byte{2})
+	}()
+	i, err := Select(context.Background(), readers...)
+	c.Check(err, check.IsNil)
+	c.Check(i, check.Equals, 1)
+	// Still ready, without receiving again
+	i, err = Select(context.Background(), readers...)
+	c.Check(err, check.IsNil)
+	c.Check(i, check.Equals, 1)
+	msg, err := readers[1].ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Data, check.DeepEquals, []byte{1})
+	c.Check(msg.Gap, check.Equals, false)
+	msg, err = readers[1].ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Data, check.DeepEquals, []byte{2})
+	c.Check(msg.Gap, check.Equals, false)
+	c.Check(tees[1].Stats().Readers[0].BufferedBytes, check.Equals, int64(0))
+
+	tees[2].Close()
+	i, err = Select(context.Background(), readers...)
+	c.Check(err, check.IsNil)
+	c.Check(i, check.Equals, 2)
+	_, err = readers[2].Read(make([]byte, 1))
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestSelectAfterSetHighWater(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	r := w.NewReader(0, 4)
+	defer r.Close()
+	r.SetHighWater(8)
+
+	// The old channel is closed, but r isn't ready until something
+	// is written.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Select(ctx, r)
+	c.Check(err, check.Equals, context.DeadlineExceeded)
+
+	w.Write([]byte{1})
+	i, err := Select(context.Background(), r)
+	c.Check(err, check.IsNil)
+	c.Check(i, check.Equals, 0)
+	msg, err := r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(msg.Data, check.DeepEquals, []byte{1})
+}
@@ -0,0 +1,194 @@
+package nbtee2
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats summarizes one client's session with a StreamHandler or
+// Server. It is reported when the client disconnects.
+type ConnStats struct {
+	RemoteAddr string
+	Start      time.Time
+	Duration   time.Duration
+
+	// Bytes sent to the client.
+	Bytes int64
+
+	// Writes (and bytes) the client missed because it wasn't
+	// keeping up.
+	Dropped      int64
+	DroppedBytes int64
+
+	// Why the session ended: io.EOF if the Tee was closed,
+	// context.Canceled if the client disconnected, ErrShutdown if
+	// the client was refused because of a shutdown, or the error
+	// that occurred while sending to the client.
+	Err error
+}
+
+// Return stats for a session that started at start and streamed
+// from r, with the given result from r.WriteTo.
+func connStats(remoteAddr string, start time.Time, r *Reader, n int64, err error) ConnStats {
+	return ConnStats{
+		RemoteAddr:   remoteAddr,
+		Start:        start,
+		Duration:     r.tee().now().Sub(start),
+		Bytes:        n,
+		Dropped:      atomic.LoadInt64(&r.dropped),
+		DroppedBytes: atomic.LoadInt64(&r.droppedBytes),
+		Err:          err,
+	}
+}
+
+// A Server streams everything sent to a Tee to each client that
+// connects to a net.Listener (e.g., TCP or unix socket). Anything the
+// client sends is ignored.
+type Server struct {
+	Tee *Tee
+
+	// Options for each client's reader.
+	ReaderOptions []ReaderOption
+
+	// Maximum time a single write to a client may take before the
+	// client is disconnected. Zero means no limit.
+	WriteTimeout time.Duration
+
+	// TLSConfig, if not nil, makes Serve accept TLS connections
+	// using the given certificates, client authentication
+	// settings, etc.
+	TLSConfig *tls.Config
+
+	// Authorize, if not nil, is called for each new connection
+	// before streaming to it. If it returns an error, the
+	// connection is closed. With TLSConfig, the handshake is done
+	// before calling Authorize, so it can inspect the client's
+	// certificates with conn.(*tls.Conn).ConnectionState().
+	Authorize func(net.Conn) error
+
+	// Maximum time for the TLS handshake and Authorize together,
+	// after which the connection is closed. Default 10s.
+	HandshakeTimeout time.Duration
+
+	// Disconnected, if not nil, is called with each client's
+	// session stats after it disconnects.
+	Disconnected func(ConnStats)
+
+	mtx       sync.Mutex
+	listeners map[net.Listener]bool
+	accepted  map[net.Conn]bool // not yet streaming
+	shutdown  bool
+	sessions  sessions
+}
+
+// Serve accepts connections on l and streams to each one in a new
+// goroutine. It returns when l.Accept returns an error, or
+// ErrShutdown after Shutdown is called.
+func (srv *Server) Serve(l net.Listener) error {
+	if srv.TLSConfig != nil {
+		l = tls.NewListener(l, srv.TLSConfig)
+	}
+	srv.mtx.Lock()
+	if srv.shutdown {
+		srv.mtx.Unlock()
+		return ErrShutdown
+	}
+	if srv.listeners == nil {
+		srv.listeners = map[net.Listener]bool{}
+	}
+	srv.listeners[l] = true
+	srv.mtx.Unlock()
+	defer func() {
+		srv.mtx.Lock()
+		delete(srv.listeners, l)
+		srv.mtx.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			srv.mtx.Lock()
+			shutdown := srv.shutdown
+			srv.mtx.Unlock()
+			if shutdown {
+				return ErrShutdown
+			}
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// Shutdown closes srv's listeners and any connections still doing
+// their TLS handshake or authorization, then stops streaming new writes to
+// connected clients, lets each one finish sending what its reader has
+// buffered, and waits for them to disconnect. If ctx is done first,
+// the remaining clients are disconnected immediately and Shutdown
+// returns ctx.Err().
+//
+// Shutdown does not close srv.Tee, which may have other readers. To
+// shut down a whole relay, call Shutdown on each server, then
+// Tee.CloseContext.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mtx.Lock()
+	srv.shutdown = true
+	for l := range srv.listeners {
+		l.Close()
+	}
+	for conn := range srv.accepted {
+		conn.Close()
+	}
+	srv.mtx.Unlock()
+	return srv.sessions.drain(ctx)
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if !srv.handshake(conn) {
+		return
+	}
+	st := streamToConn(context.Background(), srv.Tee, conn, srv.WriteTimeout, srv.ReaderOptions, &srv.sessions)
+	if srv.Disconnected != nil {
+		srv.Disconnected(st)
+	}
+}
+
+// Do the TLS handshake (if any) and call Authorize, with a deadline,
+// tracking conn so Shutdown can close it meanwhile. Return true if
+// conn is ready to stream to.
+func (srv *Server) handshake(conn net.Conn) bool {
+	srv.mtx.Lock()
+	if srv.shutdown {
+		srv.mtx.Unlock()
+		return false
+	}
+	if srv.accepted == nil {
+		srv.accepted = map[net.Conn]bool{}
+	}
+	srv.accepted[conn] = true
+	srv.mtx.Unlock()
+	defer func() {
+		srv.mtx.Lock()
+		delete(srv.accepted, conn)
+		srv.mtx.Unlock()
+	}()
+	timeout := srv.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return false
+		}
+	}
+	if srv.Authorize != nil {
+		if err := srv.Authorize(conn); err != nil {
+			return false
+		}
+	}
+	return conn.SetDeadline(time.Time{}) == nil
+}
@@ -0,0 +1,189 @@
+package nbtee2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestServer(c *check.C) {
+	w := &Tee{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	srv := &Server{Tee: w, Authorize: func(conn net.Conn) error {
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ok" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	}}
+	go srv.Serve(l)
+
+	bad, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer bad.Close()
+	bad.Write([]byte("no"))
+	buf, err := ioutil.ReadAll(bad)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.HasLen, 0)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Write([]byte("ok"))
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	w.Write([]byte("bar"))
+	w.Close()
+	buf, err = ioutil.ReadAll(conn)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foobar")
+}
+
+func (s *Suite) TestServerClientHangup(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	disconnected := make(chan ConnStats, 1)
+	go (&Server{Tee: w, Disconnected: func(cs ConnStats) { disconnected <- cs }}).Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	c.Check(err, check.IsNil)
+	conn.Close()
+	waitReaders(c, w, 0)
+	cs := <-disconnected
+	c.Check(cs.RemoteAddr, check.Equals, conn.LocalAddr().String())
+	c.Check(cs.Bytes, check.Equals, int64(3))
+	c.Check(cs.Duration > 0, check.Equals, true)
+	c.Check(cs.Err, check.Equals, context.Canceled)
+}
+
+// Return a self-signed certificate for 127.0.0.1.
+func testCertificate(c *check.C) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, check.IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, check.IsNil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func (s *Suite) TestServerTLS(c *check.C) {
+	cert, pool := testCertificate(c)
+	w := &Tee{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	var clientCN string
+	srv := &Server{
+		Tee: w,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		},
+		Authorize: func(conn net.Conn) error {
+			clientCN = conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName
+			return nil
+		},
+	}
+	go srv.Serve(l)
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	})
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	waitReaders(c, w, 1)
+	c.Check(clientCN, check.Equals, "test")
+	w.Write([]byte("foo"))
+	w.Close()
+	buf, err := ioutil.ReadAll(conn)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+}
+
+func (s *Suite) TestServerHandshakeTimeout(c *check.C) {
+	cert, _ := testCertificate(c)
+	w := &Tee{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	srv := &Server{
+		Tee:              w,
+		TLSConfig:        &tls.Config{Certificates: []tls.Certificate{cert}},
+		HandshakeTimeout: 50 * time.Millisecond,
+	}
+	go srv.Serve(l)
+
+	// A client that never sends a ClientHello is disconnected.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	c.Check(err, check.Equals, io.EOF)
+
+	// Shutdown doesn't wait for the timeout.
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	srv = &Server{
+		Tee:       w,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go srv.Serve(l)
+	conn, err = net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for {
+		srv.mtx.Lock()
+		n := len(srv.accepted)
+		srv.mtx.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Check(srv.Shutdown(ctx), check.IsNil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	c.Check(err, check.Equals, io.EOF)
+	c.Check(w.Stats().Readers, check.HasLen, 0)
+}
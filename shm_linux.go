@@ -0,0 +1,344 @@
+//go:build linux
+// +build linux
+
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Shared memory ring layout: a 64-byte header, followed by the ring.
+// Positions are byte counts since the ring was created; a record at
+// position p starts at ring offset p % capacity. Each record is a
+// 16-byte record header (data length, flags, and time as UnixNano),
+// then the data, padded to a multiple of 8 bytes. A record never
+// wraps around the end of the ring: if it doesn't fit, a wrap marker
+// is written and the record starts at offset 0.
+const (
+	shmMagic      = "NBTEESHM"
+	shmHeaderSize = 64
+
+	shmCapacity   = 8  // ring size
+	shmWritePos   = 16 // end of the last complete record
+	shmReserved   = 24 // end of the record being written
+	shmLastRecord = 32 // start of the last complete record
+	shmClosed     = 40 // 1 if the writer has finished
+
+	shmRecordHeader = 16
+	shmWrap         = 0xffffffff // record length of a wrap marker
+	shmFlagGap      = 1
+)
+
+var errShmFormat = errors.New("not an nbtee2 shared memory ring")
+
+// A ShmSink mirrors a Tee into a ring buffer in a shared memory file,
+// so other processes on the same host can subscribe with a ShmReader
+// instead of going through a socket. Put Path on a tmpfs (e.g.,
+// /dev/shm) to keep the data out of the page cache's writeback path.
+//
+// The sink never waits for ShmReaders: a reader that falls more than
+// Size bytes behind skips ahead and sees a gap, like any other
+// reader.
+type ShmSink struct {
+	Tee *Tee
+
+	// File to create for the ring. An existing file is replaced,
+	// not truncated: see Run.
+	Path string
+
+	// Size of the ring in bytes, rounded up to a multiple of 8.
+	// Writes larger than the ring are dropped. Default 4 MiB.
+	Size int
+
+	// Options for the sink's reader.
+	ReaderOptions []ReaderOption
+}
+
+// Run creates the ring and copies writes into it until the Tee is
+// closed (returning nil) or ctx is cancelled (returning ctx.Err()).
+// Either way, ShmReaders see EOF after reading what's in the ring.
+// The file is not removed.
+//
+// The ring is built in a new file in Path's directory and renamed
+// over Path, because shrinking a file that readers have mapped would
+// crash them with SIGBUS. If Path already held a ring (e.g., left by
+// a sink that crashed), it is marked finished, so readers still
+// attached to it see EOF and can reopen Path.
+func (ss *ShmSink) Run(ctx context.Context) error {
+	capacity := ss.Size
+	if capacity <= 0 {
+		capacity = 4 << 20
+	}
+	capacity = (capacity + 7) &^ 7
+	mem, err := ss.create(capacity)
+	if err != nil {
+		return err
+	}
+	defer syscall.Munmap(mem)
+	ring := mem[shmHeaderSize:]
+	defer atomic.StoreUint64(shmField(mem, shmClosed), 1)
+
+	r := ss.Tee.newLiveReader(ctx, ss.ReaderOptions...)
+	defer r.Close()
+	var pos uint64
+	gap := false
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		gap = gap || msg.Gap
+		size := uint64(shmRecordHeader+len(msg.Data)+7) &^ 7
+		if size > uint64(capacity) {
+			gap = true
+			continue
+		}
+		off := pos % uint64(capacity)
+		if off+size > uint64(capacity) {
+			atomic.StoreUint64(shmField(mem, shmReserved), pos+uint64(capacity)-off+size)
+			binary.LittleEndian.PutUint32(ring[off:], shmWrap)
+			pos += uint64(capacity) - off
+			off = 0
+		} else {
+			atomic.StoreUint64(shmField(mem, shmReserved), pos+size)
+		}
+		var flags uint32
+		if gap {
+			flags |= shmFlagGap
+		}
+		binary.LittleEndian.PutUint32(ring[off:], uint32(len(msg.Data)))
+		binary.LittleEndian.PutUint32(ring[off+4:], flags)
+		binary.LittleEndian.PutUint64(ring[off+8:], uint64(msg.Time.UnixNano()))
+		copy(ring[off+shmRecordHeader:], msg.Data)
+		atomic.StoreUint64(shmField(mem, shmWritePos), pos+size)
+		atomic.StoreUint64(shmField(mem, shmLastRecord), pos)
+		pos += size
+		gap = false
+	}
+}
+
+// Create and map a new ring at ss.Path, replacing any file already
+// there.
+func (ss *ShmSink) create(capacity int) ([]byte, error) {
+	f, err := ioutil.TempFile(filepath.Dir(ss.Path), "."+filepath.Base(ss.Path)+".")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mem, err := ss.init(f, capacity)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	// Open the old ring before the rename, so we finish the one
+	// its readers have mapped.
+	old, oldErr := os.OpenFile(ss.Path, os.O_RDWR, 0)
+	if err := os.Rename(f.Name(), ss.Path); err != nil {
+		syscall.Munmap(mem)
+		os.Remove(f.Name())
+		if oldErr == nil {
+			old.Close()
+		}
+		return nil, err
+	}
+	if oldErr == nil {
+		finishShm(old)
+		old.Close()
+	}
+	return mem, nil
+}
+
+// Size, map, and initialize a new (empty) ring file.
+func (ss *ShmSink) init(f *os.File, capacity int) ([]byte, error) {
+	if err := f.Chmod(0644); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(shmHeaderSize + capacity)); err != nil {
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, shmHeaderSize+capacity, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	copy(mem, shmMagic)
+	binary.LittleEndian.PutUint64(mem[shmCapacity:], uint64(capacity))
+	return mem, nil
+}
+
+// Mark the ring in f finished, if it is one.
+func finishShm(f *os.File) {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() < shmHeaderSize {
+		return
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, shmHeaderSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return
+	}
+	defer syscall.Munmap(mem)
+	if string(mem[:len(shmMagic)]) == shmMagic {
+		atomic.StoreUint64(shmField(mem, shmClosed), 1)
+	}
+}
+
+// A ShmReader reads a shared memory ring written by a ShmSink,
+// possibly in another process.
+type ShmReader struct {
+	// How often to check for new data while waiting. Default 1ms.
+	PollInterval time.Duration
+
+	// Clock to wait on between checks, if it is a TimerClock.
+	// Default: the system clock.
+	Clock Clock
+
+	f        *os.File
+	mem      []byte
+	ring     []byte
+	capacity uint64
+	pos      uint64
+	buf      []byte
+}
+
+// OpenShm opens a ring created by a ShmSink. The first message read
+// is the next one written.
+func OpenShm(path string) (*ShmReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() < shmHeaderSize {
+		f.Close()
+		return nil, errShmFormat
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	sr := &ShmReader{f: f, mem: mem, capacity: binary.LittleEndian.Uint64(mem[shmCapacity:])}
+	if string(mem[:len(shmMagic)]) != shmMagic || sr.capacity == 0 || sr.capacity%8 != 0 || sr.capacity > uint64(len(mem)-shmHeaderSize) {
+		sr.Close()
+		return nil, errShmFormat
+	}
+	sr.ring = mem[shmHeaderSize : shmHeaderSize+sr.capacity]
+	sr.pos = atomic.LoadUint64(shmField(mem, shmWritePos))
+	return sr, nil
+}
+
+// ReadMessage returns the next message in the ring, waiting if
+// necessary. Only the Data, Time, and Gap fields are set. Data is
+// only valid until the next call to ReadMessage. Gap is set if the
+// reader fell behind and skipped ahead, or the writer's Tee reader
+// missed writes.
+//
+// When the writer has finished and everything has been read,
+// ReadMessage returns io.EOF. If ctx is cancelled first, it returns
+// ctx.Err().
+func (sr *ShmReader) ReadMessage(ctx context.Context) (Message, error) {
+	interval := sr.PollInterval
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	gap := false
+	for {
+		wp := atomic.LoadUint64(shmField(sr.mem, shmWritePos))
+		if wp < sr.pos {
+			// The writer started over.
+			sr.pos, gap = 0, true
+			continue
+		}
+		if wp == sr.pos {
+			if atomic.LoadUint64(shmField(sr.mem, shmClosed)) != 0 && atomic.LoadUint64(shmField(sr.mem, shmWritePos)) == sr.pos {
+				return Message{}, io.EOF
+			}
+			if err := clockSleep(ctx, sr.Clock, interval); err != nil {
+				return Message{}, err
+			}
+			continue
+		}
+		if wp-sr.pos > sr.capacity {
+			sr.skipAhead(wp)
+			gap = true
+			continue
+		}
+		off := sr.pos % sr.capacity
+		size := binary.LittleEndian.Uint32(sr.ring[off:])
+		if size == shmWrap {
+			sr.pos += sr.capacity - off
+			continue
+		}
+		if off+shmRecordHeader > sr.capacity || uint64(size) > sr.capacity-off-shmRecordHeader {
+			// This can't be a record the writer finished, so it
+			// was overwritten before we read the header.
+			sr.skipPast(wp)
+			gap = true
+			continue
+		}
+		flags := binary.LittleEndian.Uint32(sr.ring[off+4:])
+		t := int64(binary.LittleEndian.Uint64(sr.ring[off+8:]))
+		sr.buf = append(sr.buf[:0], sr.ring[off+shmRecordHeader:off+shmRecordHeader+uint64(size)]...)
+		if atomic.LoadUint64(shmField(sr.mem, shmReserved))-sr.pos > sr.capacity {
+			// Overwritten while we were reading it.
+			sr.skipAhead(atomic.LoadUint64(shmField(sr.mem, shmWritePos)))
+			gap = true
+			continue
+		}
+		sr.pos += (shmRecordHeader + uint64(size) + 7) &^ 7
+		return Message{
+			Data: sr.buf,
+			Time: time.Unix(0, t),
+			Gap:  gap || flags&shmFlagGap != 0,
+		}, nil
+	}
+}
+
+// Skip to the newest complete record, or to wp if that isn't known
+// yet.
+func (sr *ShmReader) skipAhead(wp uint64) {
+	sr.pos = atomic.LoadUint64(shmField(sr.mem, shmLastRecord))
+	if sr.pos > wp {
+		sr.pos = wp
+	}
+}
+
+// Like skipAhead, but make sure to move past the current record, so
+// a corrupt one isn't read again.
+func (sr *ShmReader) skipPast(wp uint64) {
+	pos := sr.pos
+	sr.skipAhead(wp)
+	if sr.pos <= pos {
+		sr.pos = wp
+	}
+}
+
+// Close unmaps the ring. It must not be called concurrently with
+// ReadMessage.
+func (sr *ShmReader) Close() error {
+	err := syscall.Munmap(sr.mem)
+	if err2 := sr.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Return a pointer to the 8-byte header field at offset off.
+func shmField(mem []byte, off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&mem[off]))
+}
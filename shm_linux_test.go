@@ -0,0 +1,199 @@
+//go:build linux
+// +build linux
+
+package nbtee2
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestShm(c *check.C) {
+	w := &Tee{}
+	path := filepath.Join(c.MkDir(), "ring")
+	sink := &ShmSink{Tee: w, Path: path, Size: 100}
+	done := make(chan error)
+	go func() { done <- sink.Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	sr, err := OpenShm(path)
+	c.Assert(err, check.IsNil)
+	defer sr.Close()
+	ctx := context.Background()
+
+	// Enough to wrap around the ring several times.
+	for i := byte(0); i < 20; i++ {
+		w.Write([]byte{i, i, i, i, i})
+		msg, err := sr.ReadMessage(ctx)
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Data, check.DeepEquals, []byte{i, i, i, i, i})
+		c.Check(msg.Gap, check.Equals, false)
+		c.Check(msg.Time.IsZero(), check.Equals, false)
+	}
+
+	// Fall behind by more than the ring size.
+	for i := byte(0); i < 10; i++ {
+		w.Write([]byte{i, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	}
+	w.Write([]byte("last"))
+	for atomic.LoadUint64(shmField(sr.mem, shmWritePos))-sr.pos < 10*32+24 {
+		time.Sleep(time.Millisecond)
+	}
+	msg, err := sr.ReadMessage(ctx)
+	c.Assert(err, check.IsNil)
+	c.Check(msg.Gap, check.Equals, true)
+	c.Check(string(msg.Data), check.Equals, "last")
+
+	// Too big for the ring.
+	w.Write(make([]byte, 200))
+	w.Write([]byte("after"))
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	msg, err = sr.ReadMessage(ctx)
+	c.Assert(err, check.IsNil)
+	c.Check(msg.Gap, check.Equals, true)
+	c.Check(string(msg.Data), check.Equals, "after")
+	_, err = sr.ReadMessage(ctx)
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestShmCancel(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	path := filepath.Join(c.MkDir(), "ring")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- (&ShmSink{Tee: w, Path: path}).Run(ctx) }()
+	waitReaders(c, w, 1)
+	sr, err := OpenShm(path)
+	c.Assert(err, check.IsNil)
+	defer sr.Close()
+	rctx, rcancel := context.WithCancel(context.Background())
+	rcancel()
+	_, err = sr.ReadMessage(rctx)
+	c.Check(err, check.Equals, context.Canceled)
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+	_, err = sr.ReadMessage(context.Background())
+	c.Check(err, check.Equals, io.EOF)
+
+	_, err = OpenShm(filepath.Join(c.MkDir(), "missing"))
+	c.Check(err, check.NotNil)
+}
+
+func (s *Suite) TestShmOverwrittenHeader(c *check.C) {
+	mem := make([]byte, shmHeaderSize+64)
+	sr := &ShmReader{mem: mem, ring: mem[shmHeaderSize:], capacity: 64}
+	put := func(field int, v uint64) { atomic.StoreUint64(shmField(mem, field), v) }
+
+	// The record at offset 56 has been overwritten with junk that
+	// doesn't fit before the end of the ring. The newest complete
+	// record is at offset 0.
+	binary.LittleEndian.PutUint32(sr.ring[56:], 100)
+	binary.LittleEndian.PutUint32(sr.ring[0:], 2)
+	copy(sr.ring[shmRecordHeader:], "ok")
+	sr.pos = 56
+	put(shmLastRecord, 64)
+	put(shmWritePos, 88)
+	put(shmReserved, 88)
+	msg, err := sr.ReadMessage(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "ok")
+	c.Check(msg.Gap, check.Equals, true)
+
+	// If the newest record is junk too, skip to the write position
+	// rather than reading it again.
+	binary.LittleEndian.PutUint32(sr.ring[24:], 1000)
+	sr.pos = 88
+	put(shmLastRecord, 88)
+	put(shmWritePos, 112)
+	put(shmReserved, 112)
+	put(shmClosed, 1)
+	_, err = sr.ReadMessage(context.Background())
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestShmReplace(c *check.C) {
+	path := filepath.Join(c.MkDir(), "ring")
+	ctx := context.Background()
+	run := func(w *Tee, size int) chan error {
+		done := make(chan error, 1)
+		go func() { done <- (&ShmSink{Tee: w, Path: path, Size: size}).Run(ctx) }()
+		waitReaders(c, w, 1)
+		return done
+	}
+
+	// A stale ring whose sink is (as far as its readers can tell)
+	// still running.
+	w1 := &Tee{}
+	done1 := run(w1, 1000)
+	old, err := OpenShm(path)
+	c.Assert(err, check.IsNil)
+	defer old.Close()
+	w1.Write([]byte("old"))
+	for atomic.LoadUint64(shmField(old.mem, shmWritePos)) == old.pos {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A new, smaller ring replaces it.
+	w2 := &Tee{}
+	done2 := run(w2, 100)
+	msg, err := old.ReadMessage(ctx)
+	c.Assert(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "old")
+	_, err = old.ReadMessage(ctx)
+	c.Check(err, check.Equals, io.EOF)
+
+	sr, err := OpenShm(path)
+	c.Assert(err, check.IsNil)
+	defer sr.Close()
+	c.Check(sr.capacity, check.Equals, uint64(104))
+	w2.Write([]byte("new"))
+	msg, err = sr.ReadMessage(ctx)
+	c.Assert(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "new")
+
+	w1.Close()
+	w2.Close()
+	c.Check(<-done1, check.IsNil)
+	c.Check(<-done2, check.IsNil)
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	c.Assert(err, check.IsNil)
+	c.Check(entries, check.HasLen, 1)
+}
+
+func (s *Suite) TestShmReaderClock(c *check.C) {
+	w := &Tee{}
+	path := filepath.Join(c.MkDir(), "ring")
+	done := make(chan error)
+	go func() { done <- (&ShmSink{Tee: w, Path: path}).Run(context.Background()) }()
+	waitReaders(c, w, 1)
+	sr, err := OpenShm(path)
+	c.Assert(err, check.IsNil)
+	defer sr.Close()
+	clock := &fakeTimerClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	sr.Clock = clock
+	sr.PollInterval = time.Minute
+	got := make(chan string, 1)
+	go func() {
+		msg, err := sr.ReadMessage(context.Background())
+		c.Check(err, check.IsNil)
+		got <- string(msg.Data)
+	}()
+
+	// The reader doesn't see the write until it polls again, a
+	// minute later on the fake clock.
+	clock.waitTimers(c, 1)
+	w.Write([]byte("foo"))
+	w.Close()
+	c.Check(<-done, check.IsNil)
+	c.Check(len(got), check.Equals, 0)
+	clock.Advance(time.Minute)
+	c.Check(<-got, check.Equals, "foo")
+}
@@ -0,0 +1,103 @@
+package nbtee2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrShutdown is returned by Server.Serve after Shutdown is called,
+// and reported in ConnStats for clients refused during shutdown.
+var ErrShutdown = errors.New("nbtee2: server shut down")
+
+// CloseContext closes w like Close, then waits for every reader to
+// finish reading what was buffered when w was closed (or to be
+// closed by its consumer). If ctx is done first, whatever is still
+// buffered is discarded, including anything a reader has already
+// received but not returned to its consumer, so readers reach EOF as
+// soon as they read again, and CloseContext returns ctx.Err().
+func (w *Tee) CloseContext(ctx context.Context) error {
+	w.mtx.Lock()
+	readers := w.closeLocked()
+	w.mtx.Unlock()
+	for i, r := range readers {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			for _, r := range readers[i:] {
+				atomic.StoreInt32(&r.discard, 1)
+				tee := r.lockTee()
+				for r.discardOldest() != nil {
+				}
+				tee.mtx.Unlock()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// sessions tracks the active sessions of a server component so they
+// can be drained on shutdown.
+type sessions struct {
+	mtx      sync.Mutex
+	active   map[*Reader]func() // reader -> force stop
+	wg       sync.WaitGroup
+	shutdown bool
+}
+
+// Register a session streaming from r. Shutdown calls stop if the
+// session doesn't end in time. Return false (without registering)
+// if shutdown has started, otherwise the caller must call done when
+// the session ends.
+func (ss *sessions) add(r *Reader, stop func()) bool {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	if ss.shutdown {
+		return false
+	}
+	if ss.active == nil {
+		ss.active = map[*Reader]func(){}
+	}
+	ss.active[r] = stop
+	ss.wg.Add(1)
+	return true
+}
+
+func (ss *sessions) done(r *Reader) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	delete(ss.active, r)
+	ss.wg.Done()
+}
+
+// Refuse new sessions, detach each active session's reader so it
+// ends after sending what's buffered, and wait for them to end. If
+// ctx is done first, force the remaining sessions to stop, and
+// return ctx.Err().
+func (ss *sessions) drain(ctx context.Context) error {
+	ss.mtx.Lock()
+	ss.shutdown = true
+	for r := range ss.active {
+		r.detach()
+	}
+	ss.mtx.Unlock()
+	ended := make(chan struct{})
+	go func() {
+		ss.wg.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+		return nil
+	case <-ctx.Done():
+	}
+	ss.mtx.Lock()
+	for _, stop := range ss.active {
+		stop()
+	}
+	ss.mtx.Unlock()
+	<-ended
+	return ctx.Err()
+}
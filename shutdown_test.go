@@ -0,0 +1,152 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestCloseContext(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	got := make(chan []byte)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		buf, _ := ioutil.ReadAll(r)
+		got <- buf
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Check(w.CloseContext(ctx), check.IsNil)
+	c.Check(<-got, check.DeepEquals, []byte{1, 2})
+
+	// A reader that doesn't finish in time loses what's buffered.
+	w = &Tee{}
+	r = w.NewReader(0, 4)
+	w.Write([]byte{1})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Check(w.CloseContext(ctx), check.Equals, context.DeadlineExceeded)
+	_, err := r.Read(make([]byte, 1))
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestCloseContextAfterSetHighWater(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	r.SetHighWater(20)
+	w.Write([]byte{1})
+	got := make(chan []byte)
+	go func() {
+		buf, _ := ioutil.ReadAll(r)
+		got <- buf
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Check(w.CloseContext(ctx), check.IsNil)
+	c.Check(<-got, check.DeepEquals, []byte{1})
+}
+
+func (s *Suite) TestCloseContextDiscardsBacklog(c *check.C) {
+	w := &Tee{Retain: time.Minute}
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	// r starts with two retained writes in its backlog, and one in
+	// a channel that has been replaced.
+	r := w.NewReaderAt(context.Background(), time.Time{})
+	w.Write([]byte{3})
+	r.SetHighWater(20)
+	w.Write([]byte{4})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Check(w.CloseContext(ctx), check.Equals, context.DeadlineExceeded)
+	_, err := r.ReadMessage()
+	c.Check(err, check.Equals, io.EOF)
+	_, err = r.Read(make([]byte, 1))
+	c.Check(err, check.Equals, io.EOF)
+	c.Check(atomic.LoadInt64(&r.dropped), check.Equals, int64(4))
+	c.Check(atomic.LoadInt64(&r.bufferedBytes), check.Equals, int64(0))
+	select {
+	case <-r.done:
+	default:
+		c.Error("reader not finished")
+	}
+}
+
+func (s *Suite) TestServerShutdown(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	srv := &Server{Tee: w}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Check(srv.Shutdown(ctx), check.IsNil)
+	c.Check(<-served, check.Equals, ErrShutdown)
+	buf, err := ioutil.ReadAll(conn)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+	c.Check(w.Stats().Readers, check.HasLen, 0)
+}
+
+func (s *Suite) TestStreamHandlerShutdown(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	disconnected := make(chan ConnStats, 2)
+	h := &StreamHandler{Tee: w, Disconnected: func(cs ConnStats) { disconnected <- cs }}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Check(h.Shutdown(ctx), check.IsNil)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+	c.Check((<-disconnected).Err, check.Equals, io.EOF)
+
+	resp, err = http.Get(srv.URL)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, check.Equals, http.StatusServiceUnavailable)
+	c.Check((<-disconnected).Err, check.Equals, ErrShutdown)
+}
+
+func (s *Suite) TestConnSetShutdown(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	cs := &ConnSet{Tee: w}
+	conn, client := net.Pipe()
+	defer client.Close()
+	cs.Add(conn)
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	// The client never reads, so shutdown times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Check(cs.Shutdown(ctx), check.Equals, context.DeadlineExceeded)
+	_, err := client.Read(make([]byte, 1))
+	c.Check(err, check.Equals, io.EOF)
+}
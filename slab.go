@@ -0,0 +1,35 @@
+package nbtee2
+
+// Writes up to smallMessageSize bytes are carved out of shared slabs
+// instead of being allocated individually, so a stream of tiny writes
+// costs a fraction of an allocation per write. Message bufs are never
+// modified or reused, so a slab stays in memory until all of its
+// messages are unreferenced; the cost is that one long-lived small
+// message pins a whole slab.
+const (
+	smallMessageSize = 64
+	slabMessages     = 64
+)
+
+// Return a new message with a buf of length n, for the caller to
+// fill in before sending.
+func (w *Tee) newMessage(n int) *message {
+	if n > smallMessageSize {
+		return &message{buf: make([]byte, n)}
+	}
+	w.slabMtx.Lock()
+	defer w.slabMtx.Unlock()
+	if len(w.msgSlab) == 0 {
+		w.msgSlab = make([]message, slabMessages)
+	}
+	m := &w.msgSlab[0]
+	w.msgSlab = w.msgSlab[1:]
+	if len(w.bufSlab) < n {
+		w.bufSlab = make([]byte, slabMessages*smallMessageSize)
+	}
+	// Limit the capacity, so an append can't overwrite the next
+	// message.
+	m.buf = w.bufSlab[:n:n]
+	w.bufSlab = w.bufSlab[n:]
+	return m
+}
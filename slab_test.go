@@ -0,0 +1,40 @@
+package nbtee2
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestSmallMessages(c *check.C) {
+	w := &Tee{}
+	r := w.newLiveReader(context.Background(), WithWatermarks(0, 1000))
+	for i := 0; i < 300; i++ {
+		w.Write([]byte{byte(i), byte(i >> 8)})
+	}
+	w.Write(make([]byte, smallMessageSize+1))
+	w.Close()
+	for i := 0; i < 300; i++ {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Data, check.DeepEquals, []byte{byte(i), byte(i >> 8)})
+		c.Check(cap(msg.Data), check.Equals, 2)
+	}
+	msg, err := r.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Check(msg.Data, check.HasLen, smallMessageSize+1)
+
+	p := []byte("tiny")
+	allocs := testing.AllocsPerRun(1000, func() { w.Write(p) })
+	c.Check(allocs < 0.5, check.Equals, true, check.Commentf("%v allocs per write", allocs))
+	allocs = testing.AllocsPerRun(1000, func() { w.WriteString("tiny") })
+	c.Check(allocs < 0.5, check.Equals, true, check.Commentf("%v allocs per WriteString", allocs))
+	src := bytes.NewReader(nil)
+	allocs = testing.AllocsPerRun(1000, func() {
+		src.Reset(p)
+		w.WriteFrom(src, len(p))
+	})
+	c.Check(allocs < 0.5, check.Equals, true, check.Commentf("%v allocs per WriteFrom", allocs))
+}
@@ -0,0 +1,189 @@
+package nbtee2
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of a Tee's state, returned by Tee.Stats.
+type Stats struct {
+	Started  time.Time // time of the first write
+	Writes   int64     // total writes
+	Bytes    int64     // total bytes written
+	Deduped  int64     // writes suppressed by Tee.Dedup
+	Retained int       // writes currently in the retention window
+	Readers  []ReaderStats
+}
+
+// ReaderStats describes one attached reader.
+type ReaderStats struct {
+	ID uint64 // unique within the Tee, assigned in order of creation
+
+	// Writes waiting in the reader's buffer, and the size of the
+	// buffer (its high watermark). When Buffered reaches Capacity,
+	// new writes are dropped.
+	Buffered int
+	Capacity int
+
+	// Total size of the writes waiting in the reader's buffer.
+	BufferedBytes int64
+
+	// How long the oldest write in the reader's buffer has been
+	// waiting, or 0 if the buffer is empty. This is approximate:
+	// it can overestimate by up to the interval between writes.
+	Age time.Duration
+
+	// Writes (and their total size) that this reader has missed
+	// because its buffer was full or it was catching up.
+	Dropped      int64
+	DroppedBytes int64
+
+	// How long the reader has had data waiting without its consumer
+	// completing a Read. Zero if the reader is waiting for data, or
+	// has nothing pending. See Watchdog.
+	Stalled time.Duration
+
+	// When the consumer last received data from the reader (or
+	// when the reader was created, if it hasn't yet), and when the
+	// reader last received a write into its buffer (zero if it
+	// hasn't). An old LastWrite means no data is flowing; an old
+	// LastRead with a recent LastWrite means the consumer has
+	// stopped reading.
+	LastRead  time.Time
+	LastWrite time.Time
+}
+
+// Fill returns the fraction of the reader's buffer that is in use,
+// between 0 and 1. A reader with no buffer is always full.
+func (rs ReaderStats) Fill() float64 {
+	if rs.Capacity == 0 {
+		return 1
+	}
+	return float64(rs.Buffered) / float64(rs.Capacity)
+}
+
+// ReaderSummary describes a reader's whole session. It is returned by
+// Reader.CloseWithStats.
+type ReaderSummary struct {
+	Attached time.Time     // when the reader was created
+	Duration time.Duration // how long it was attached
+
+	// Bytes taken by the consumer through Read, WriteTo, etc.
+	Bytes int64
+
+	// Writes (and their total size) that the reader missed.
+	Dropped      int64
+	DroppedBytes int64
+}
+
+// CloseWithStats closes r, like Close, and returns a summary of its
+// session, so per-connection accounting doesn't need to poll
+// Tee.Stats.
+func (r *Reader) CloseWithStats() ReaderSummary {
+	r.Close()
+	return ReaderSummary{
+		Attached:     r.attached,
+		Duration:     r.tee().now().Sub(r.attached),
+		Bytes:        atomic.LoadInt64(&r.delivered),
+		Dropped:      atomic.LoadInt64(&r.dropped),
+		DroppedBytes: atomic.LoadInt64(&r.droppedBytes),
+	}
+}
+
+// Stats returns a snapshot of w's state. Readers are sorted by ID.
+//
+// Stats is cheap enough to call frequently, e.g., from a metrics
+// collector.
+func (w *Tee) Stats() Stats {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.statsLocked(w.now())
+}
+
+// Caller must have w.mtx.
+func (w *Tee) statsLocked(now time.Time) Stats {
+	st := Stats{
+		Started:  w.started,
+		Writes:   w.writes,
+		Bytes:    w.bytes,
+		Deduped:  w.deduped,
+		Retained: len(w.history),
+		Readers:  make([]ReaderStats, 0, len(w.readers)),
+	}
+	for _, r := range w.sortedReaders() {
+		st.Readers = append(st.Readers, r.stats(now))
+	}
+	return st
+}
+
+// Return w's readers, sorted by ID. Caller must have w.mtx.
+func (w *Tee) sortedReaders() []*Reader {
+	readers := make([]*Reader, 0, len(w.readers))
+	for r := range w.readers {
+		readers = append(readers, r)
+	}
+	sort.Slice(readers, func(i, j int) bool {
+		return readers[i].id < readers[j].id
+	})
+	return readers
+}
+
+func (r *Reader) stats(now time.Time) ReaderStats {
+	return ReaderStats{
+		ID:            r.id,
+		Buffered:      r.buffered(),
+		Capacity:      cap(r.ch),
+		BufferedBytes: atomic.LoadInt64(&r.bufferedBytes),
+		Dropped:       atomic.LoadInt64(&r.dropped),
+		DroppedBytes:  atomic.LoadInt64(&r.droppedBytes),
+		Stalled:       r.stalled(now),
+		Age:           r.age(now),
+		LastRead:      time.Unix(0, atomic.LoadInt64(&r.lastRead)),
+		LastWrite:     unixNanoTime(atomic.LoadInt64(&r.lastWrite)),
+	}
+}
+
+// Convert t to a time.Time, or the zero time if t is 0.
+func unixNanoTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+func (r *Reader) age(now time.Time) time.Duration {
+	if r.buffered() == 0 {
+		return 0
+	}
+	if d := now.Sub(time.Unix(0, atomic.LoadInt64(&r.pendingSince))); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// SlowestReaderLag returns the largest number of buffered writes,
+// buffered bytes, and buffer age (see ReaderStats) among w's readers,
+// or zeroes if there are no readers or all are up to date. Each value
+// is a maximum over all readers, so they may come from different
+// readers.
+//
+// A producer can use this to adapt (e.g., lower its bitrate) when
+// readers are struggling to keep up, instead of letting data drop.
+func (w *Tee) SlowestReaderLag() (writes int, bytes int64, age time.Duration) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	now := w.now()
+	for r := range w.readers {
+		if n := r.buffered(); n > writes {
+			writes = n
+		}
+		if n := atomic.LoadInt64(&r.bufferedBytes); n > bytes {
+			bytes = n
+		}
+		if d := r.age(now); d > age {
+			age = d
+		}
+	}
+	return
+}
@@ -0,0 +1,111 @@
+package nbtee2
+
+import (
+	"io/ioutil"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestStats(c *check.C) {
+	w := &Tee{}
+	r1 := w.NewReader(0, 1)
+	r4 := w.NewReader(0, 4)
+	w.Write([]byte{1, 2, 3})
+	w.Write([]byte{4, 5})
+
+	st := w.Stats()
+	c.Check(st.Writes, check.Equals, int64(2))
+	c.Check(st.Bytes, check.Equals, int64(5))
+	c.Assert(st.Readers, check.HasLen, 2)
+	for i := range st.Readers {
+		// Neither reader has been read yet.
+		c.Check(st.Readers[i].Stalled > 0, check.Equals, true)
+		st.Readers[i].Stalled = 0
+		c.Check(st.Readers[i].Age > 0, check.Equals, true)
+		st.Readers[i].Age = 0
+		c.Check(st.Readers[i].LastWrite.IsZero(), check.Equals, false)
+		c.Check(st.Readers[i].LastRead.After(st.Readers[i].LastWrite), check.Equals, false)
+		st.Readers[i].LastRead = time.Time{}
+		st.Readers[i].LastWrite = time.Time{}
+	}
+	c.Check(st.Readers[0], check.DeepEquals, ReaderStats{
+		ID:            1,
+		Buffered:      1,
+		Capacity:      1,
+		BufferedBytes: 3,
+		Dropped:       1,
+		DroppedBytes:  2,
+	})
+	c.Check(st.Readers[0].Fill(), check.Equals, 1.0)
+	c.Check(st.Readers[1], check.DeepEquals, ReaderStats{
+		ID:            2,
+		Buffered:      2,
+		Capacity:      4,
+		BufferedBytes: 5,
+	})
+	c.Check(st.Readers[1].Fill(), check.Equals, 0.5)
+
+	buf := make([]byte, 8)
+	n, err := r4.Read(buf)
+	c.Check(err, check.IsNil)
+	c.Check(n, check.Equals, 3)
+	st = w.Stats()
+	c.Check(st.Readers[1].LastRead.Before(st.Readers[1].LastWrite), check.Equals, false)
+	c.Check(st.Readers[1].Buffered, check.Equals, 1)
+	c.Check(st.Readers[1].BufferedBytes, check.Equals, int64(2))
+
+	r1.Close()
+	c.Check(w.Stats().Readers, check.HasLen, 1)
+	w.Close()
+	ioutil.ReadAll(r4)
+	c.Check(w.Stats().Readers, check.HasLen, 0)
+}
+
+func (s *Suite) TestSlowestReaderLag(c *check.C) {
+	w := &Tee{}
+	writes, bytes, age := w.SlowestReaderLag()
+	c.Check(writes, check.Equals, 0)
+	c.Check(bytes, check.Equals, int64(0))
+	c.Check(age, check.Equals, time.Duration(0))
+
+	r1 := w.NewReader(0, 4)
+	r2 := w.NewReader(0, 4)
+	w.Write([]byte{1, 2, 3})
+	time.Sleep(10 * time.Millisecond)
+	w.Write([]byte{4, 5})
+	buf := make([]byte, 8)
+	r1.Read(buf)
+
+	writes, bytes, age = w.SlowestReaderLag()
+	c.Check(writes, check.Equals, 2)
+	c.Check(bytes, check.Equals, int64(5))
+	c.Check(age >= 10*time.Millisecond, check.Equals, true)
+
+	r1.Read(buf)
+	r2.Read(buf)
+	r2.Read(buf)
+	writes, bytes, age = w.SlowestReaderLag()
+	c.Check(writes, check.Equals, 0)
+	c.Check(bytes, check.Equals, int64(0))
+	c.Check(age, check.Equals, time.Duration(0))
+	w.Close()
+}
+
+func (s *Suite) TestCloseWithStats(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 2)
+	w.Write([]byte{1, 2, 3})
+	w.Write([]byte{4, 5})
+	w.Write([]byte{6})
+	buf := make([]byte, 2)
+	r.Read(buf)
+	r.Read(buf)
+	sum := r.CloseWithStats()
+	c.Check(sum.Bytes, check.Equals, int64(3))
+	c.Check(sum.Dropped, check.Equals, int64(1))
+	c.Check(sum.DroppedBytes, check.Equals, int64(1))
+	c.Check(sum.Duration > 0, check.Equals, true)
+	c.Check(time.Since(sum.Attached) >= sum.Duration, check.Equals, true)
+	c.Check(w.Stats().Readers, check.HasLen, 0)
+}
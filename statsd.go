@@ -0,0 +1,150 @@
+package nbtee2
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A StatsdEmitter periodically sends a Tee's stats to a statsd (or
+// DogStatsD) server, for deployments that collect metrics that way
+// instead of polling Tee.Stats.
+//
+// Each interval, it sends these metrics, each name preceded by
+// Prefix:
+//
+//	writes         counter  writes since the last interval
+//	bytes          counter  bytes written since the last interval
+//	dropped        counter  writes dropped by readers
+//	dropped_bytes  counter  total size of dropped writes
+//	readers        gauge    attached readers
+//	buffered_bytes gauge    total size of writes waiting in readers' buffers
+//	retained       gauge    writes in the retention window
+//
+// Drops are counted from readers attached at both ends of an
+// interval, so drops by a reader that detaches before it is next
+// sampled are not counted.
+type StatsdEmitter struct {
+	Tee *Tee
+
+	// Address of the statsd server. Default "127.0.0.1:8125".
+	// Ignored if Conn is set.
+	Addr string
+
+	// Connection to send packets on. If nil, Run dials Addr using
+	// UDP.
+	Conn net.Conn
+
+	// Prefix for metric names, e.g., "myapp.tee.".
+	Prefix string
+
+	// DogStatsD tags ("key:value" or "key") to add to every metric.
+	// Leave empty for plain statsd.
+	Tags []string
+
+	// How often to send. Default 10s.
+	Interval time.Duration
+
+	// Maximum packet size. Metrics are sent in as few packets as
+	// possible without exceeding it. Default 1432, which fits in
+	// an Ethernet frame.
+	MaxPacket int
+}
+
+// Run sends stats every Interval until ctx is cancelled, then returns
+// ctx.Err(). Errors sending packets are ignored, as statsd clients
+// usually do. Run returns an error only if it can't dial Addr.
+func (se *StatsdEmitter) Run(ctx context.Context) error {
+	conn := se.Conn
+	if conn == nil {
+		addr := se.Addr
+		if addr == "" {
+			addr = "127.0.0.1:8125"
+		}
+		var err error
+		conn, err = (&net.Dialer{}).DialContext(ctx, "udp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+	interval := se.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	prev := se.Tee.Stats()
+	for {
+		if err := se.Tee.sleep(ctx, interval); err != nil {
+			return err
+		}
+		st := se.Tee.Stats()
+		for _, pkt := range se.packets(se.metrics(prev, st)) {
+			conn.Write(pkt)
+		}
+		prev = st
+	}
+}
+
+// Return metric lines (without tags) for the interval from prev to
+// st.
+func (se *StatsdEmitter) metrics(prev, st Stats) []string {
+	type counts struct{ writes, bytes int64 }
+	before := make(map[uint64]counts, len(prev.Readers))
+	for _, rs := range prev.Readers {
+		before[rs.ID] = counts{rs.Dropped, rs.DroppedBytes}
+	}
+	var dropped, droppedBytes, buffered int64
+	for _, rs := range st.Readers {
+		buffered += rs.BufferedBytes
+		if b, ok := before[rs.ID]; ok {
+			dropped += rs.Dropped - b.writes
+			droppedBytes += rs.DroppedBytes - b.bytes
+		}
+	}
+	counter := func(name string, n int64) string {
+		return se.Prefix + name + ":" + strconv.FormatInt(n, 10) + "|c"
+	}
+	gauge := func(name string, n int64) string {
+		return se.Prefix + name + ":" + strconv.FormatInt(n, 10) + "|g"
+	}
+	return []string{
+		counter("writes", st.Writes-prev.Writes),
+		counter("bytes", st.Bytes-prev.Bytes),
+		counter("dropped", dropped),
+		counter("dropped_bytes", droppedBytes),
+		gauge("readers", int64(len(st.Readers))),
+		gauge("buffered_bytes", buffered),
+		gauge("retained", int64(st.Retained)),
+	}
+}
+
+// Add tags to lines and pack them into newline-separated packets.
+func (se *StatsdEmitter) packets(lines []string) [][]byte {
+	max := se.MaxPacket
+	if max <= 0 {
+		max = 1432
+	}
+	var tags string
+	if len(se.Tags) > 0 {
+		tags = "|#" + strings.Join(se.Tags, ",")
+	}
+	var pkts [][]byte
+	var pkt []byte
+	for _, line := range lines {
+		line += tags
+		if len(pkt) > 0 && len(pkt)+1+len(line) > max {
+			pkts = append(pkts, pkt)
+			pkt = nil
+		}
+		if len(pkt) > 0 {
+			pkt = append(pkt, '\n')
+		}
+		pkt = append(pkt, line...)
+	}
+	if len(pkt) > 0 {
+		pkts = append(pkts, pkt)
+	}
+	return pkts
+}
@@ -0,0 +1,88 @@
+package nbtee2
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestStatsdEmitter(c *check.C) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer pc.Close()
+	w := &Tee{}
+	r := w.NewReader(0, 1)
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	se := &StatsdEmitter{
+		Tee:      w,
+		Addr:     pc.LocalAddr().String(),
+		Prefix:   "tee.",
+		Tags:     []string{"env:test"},
+		Interval: time.Millisecond,
+	}
+	done := make(chan error)
+	go func() { done <- se.Run(ctx) }()
+
+	// Add up counters, and keep the latest gauges, until all the
+	// writes have been reported.
+	totals := map[string]int64{}
+	buf := make([]byte, 2000)
+	pc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for wrote := false; totals["tee.writes|c"] < 3; {
+		n, _, err := pc.ReadFrom(buf)
+		c.Assert(err, check.IsNil)
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			c.Assert(strings.HasSuffix(line, "|#env:test"), check.Equals, true, check.Commentf("%q", line))
+			line = strings.TrimSuffix(line, "|#env:test")
+			colon, bar := strings.Index(line, ":"), strings.Index(line, "|")
+			c.Assert(colon < bar, check.Equals, true, check.Commentf("%q", line))
+			v, err := strconv.ParseInt(line[colon+1:bar], 10, 64)
+			c.Assert(err, check.IsNil)
+			key := line[:colon] + line[bar:]
+			if line[bar:] == "|c" {
+				totals[key] += v
+			} else {
+				totals[key] = v
+			}
+		}
+		if !wrote {
+			// The emitter has taken its first sample.
+			wrote = true
+			w.Write([]byte("ab"))
+			w.Write([]byte("cd"))
+			w.Write([]byte("ef"))
+		}
+	}
+	c.Check(totals, check.DeepEquals, map[string]int64{
+		"tee.writes|c":         3,
+		"tee.bytes|c":          6,
+		"tee.dropped|c":        2,
+		"tee.dropped_bytes|c":  4,
+		"tee.readers|g":        1,
+		"tee.buffered_bytes|g": 2,
+		"tee.retained|g":       0,
+	})
+	cancel()
+	c.Check(<-done, check.Equals, context.Canceled)
+}
+
+func (s *Suite) TestStatsdPackets(c *check.C) {
+	se := &StatsdEmitter{MaxPacket: 10}
+	c.Check(se.packets([]string{"a:1|c", "b:2|c", "long:12345|g", "c:3|c"}), check.DeepEquals, [][]byte{
+		[]byte("a:1|c"),
+		[]byte("b:2|c"),
+		[]byte("long:12345|g"),
+		[]byte("c:3|c"),
+	})
+	se.MaxPacket = 11
+	c.Check(se.packets([]string{"a:1|c", "b:2|c", "c:3|c"}), check.DeepEquals, [][]byte{
+		[]byte("a:1|c\nb:2|c"),
+		[]byte("c:3|c"),
+	})
+}
@@ -0,0 +1,52 @@
+package nbtee2
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// The first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// SystemdListeners returns the listening sockets passed to the
+// process by systemd socket activation (see sd_listen_fds(3)), in
+// order, for use with Server.Serve, http.Serve, etc. It returns an
+// empty slice if the process was not socket-activated.
+//
+// This allows a relay to be restarted without closing its listening
+// sockets: systemd holds them and queues connections until the new
+// process starts serving. SystemdListeners unsets the LISTEN_*
+// environment variables so child processes don't inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	return systemdListeners(listenFDsStart)
+}
+
+func systemdListeners(start int) ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("nbtee2: invalid LISTEN_FDS %q", fds)
+	}
+	listeners := make([]net.Listener, 0, n)
+	for fd := start; fd < start+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("nbtee2: LISTEN_FD %d: %s", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package nbtee2
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *Suite) TestSystemdListeners(c *check.C) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	ls, err := SystemdListeners()
+	c.Check(err, check.IsNil)
+	c.Check(ls, check.HasLen, 0)
+	c.Check(os.Getenv("LISTEN_FDS"), check.Equals, "")
+
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	f, err := orig.(*net.TCPListener).File()
+	c.Assert(err, check.IsNil)
+	// systemdListeners takes ownership of the fd.
+	fd, err := syscall.Dup(int(f.Fd()))
+	c.Assert(err, check.IsNil)
+	f.Close()
+	orig.Close()
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	ls, err = systemdListeners(fd)
+	c.Assert(err, check.IsNil)
+	c.Assert(ls, check.HasLen, 1)
+	defer ls[0].Close()
+
+	w := &Tee{}
+	go (&Server{Tee: w}).Serve(ls[0])
+	conn, err := net.Dial("tcp", ls[0].Addr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	waitReaders(c, w, 1)
+	w.Write([]byte("foo"))
+	w.Close()
+	buf, err := ioutil.ReadAll(conn)
+	c.Check(err, check.IsNil)
+	c.Check(string(buf), check.Equals, "foo")
+}
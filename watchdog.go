@@ -0,0 +1,63 @@
+package nbtee2
+
+import (
+	"context"
+	"time"
+)
+
+// A Watchdog periodically checks a Tee for stuck readers: readers
+// whose consumers have had data pending for at least Timeout without
+// completing a Read (or, when using WriteTo, a write to the
+// destination). Readers that are simply waiting for new data are not
+// considered stuck.
+type Watchdog struct {
+	Tee     *Tee
+	Timeout time.Duration
+
+	// How often to check. Zero means Timeout/4.
+	Interval time.Duration
+
+	// Stuck is called when a reader is first found to be stuck.
+	// It is not called again for the same reader unless the reader
+	// recovers and then gets stuck again.
+	Stuck func(ReaderStats)
+}
+
+// Run checks for stuck readers until ctx is cancelled, then returns
+// ctx.Err().
+func (wd *Watchdog) Run(ctx context.Context) error {
+	interval := wd.Interval
+	if interval <= 0 {
+		interval = wd.Timeout / 4
+	}
+	stuck := map[uint64]bool{}
+	for {
+		if err := wd.Tee.sleep(ctx, interval); err != nil {
+			return err
+		}
+		wd.check(stuck)
+	}
+}
+
+// Call wd.Stuck for newly stuck readers. The stuck map tracks which
+// readers have already been reported.
+func (wd *Watchdog) check(stuck map[uint64]bool) {
+	readers := wd.Tee.Stats().Readers
+	seen := make(map[uint64]bool, len(readers))
+	for _, rs := range readers {
+		seen[rs.ID] = true
+		if rs.Stalled < wd.Timeout {
+			delete(stuck, rs.ID)
+		} else if !stuck[rs.ID] {
+			stuck[rs.ID] = true
+			if wd.Stuck != nil {
+				wd.Stuck(rs)
+			}
+		}
+	}
+	for id := range stuck {
+		if !seen[id] {
+			delete(stuck, id)
+		}
+	}
+}
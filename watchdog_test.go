@@ -0,0 +1,64 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+// A writer that blocks until unblock is closed.
+type blockingWriter struct{ unblock chan struct{} }
+
+func (bw blockingWriter) Write(p []byte) (int, error) {
+	<-bw.unblock
+	return len(p), nil
+}
+
+func (s *Suite) TestWatchdog(c *check.C) {
+	w := &Tee{}
+	idle := w.NewReader(0, 4)
+	defer idle.Close()
+	unread := w.NewReader(0, 4)
+	defer unread.Close()
+	wedged := w.NewReader(0, 4)
+	bw := blockingWriter{make(chan struct{})}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(bw, wedged)
+		close(copied)
+	}()
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := idle.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	stuck := make(chan uint64, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wd := &Watchdog{
+		Tee:      w,
+		Timeout:  20 * time.Millisecond,
+		Interval: time.Millisecond,
+		Stuck:    func(rs ReaderStats) { stuck <- rs.ID },
+	}
+	go wd.Run(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	c.Check(len(stuck), check.Equals, 0)
+
+	w.Write([]byte{1})
+	got := map[uint64]bool{<-stuck: true, <-stuck: true}
+	c.Check(got, check.DeepEquals, map[uint64]bool{2: true, 3: true})
+	time.Sleep(50 * time.Millisecond)
+	c.Check(len(stuck), check.Equals, 0)
+
+	close(bw.unblock)
+	w.Close()
+	<-copied
+}
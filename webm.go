@@ -0,0 +1,160 @@
+package nbtee2
+
+import (
+	"math/bits"
+)
+
+// EBML element IDs.
+const (
+	ebmlHeader   = 0x1A45DFA3
+	ebmlSegment  = 0x18538067
+	ebmlCluster  = 0x1F43B675
+	ebmlVoid     = 0xEC
+	ebmlTimecode = 0xE7
+)
+
+// Elements larger than this are assumed to be garbage.
+const webmMaxElement = 64 << 20
+
+// Cluster children, which can follow a cluster of unknown size.
+var webmClusterChildren = map[uint32]bool{
+	ebmlTimecode: true,
+	0xA3:         true, // SimpleBlock
+	0xA0:         true, // BlockGroup
+	0xA7:         true, // Position
+	0xAB:         true, // PrevSize
+	0x5854:       true, // SilentTracks
+	0xAF:         true, // EncryptedBlock
+	ebmlVoid:     true,
+	0xBF:         true, // CRC-32
+}
+
+// A WebMWriter is an io.Writer that sends a WebM (or Matroska) stream
+// to a Tee one cluster at a time, so readers that fall behind drop
+// whole clusters.
+//
+// The EBML header and the Segment's elements before the first
+// Cluster (Info, Tracks, etc.) are replayed to each new reader before
+// it joins the live stream, so a Media Source Extensions player can
+// attach at any time. Clusters are assumed to start with a keyframe.
+//
+// A Cluster of unknown size, as written by some live muxers, is sent
+// when the element following it arrives.
+//
+// Data that doesn't parse as EBML is skipped.
+type WebMWriter struct {
+	Tee *Tee
+
+	buf       []byte
+	cluster   []byte // cluster of unknown size, in progress
+	inCluster bool
+	inHeader  bool
+}
+
+// Write implements io.Writer. Data is sent to the Tee as each cluster
+// is completed. The returned error is always nil.
+func (ww *WebMWriter) Write(p []byte) (int, error) {
+	ww.buf = append(ww.buf, p...)
+	buf := ww.buf
+	for {
+		id, hlen, size, ok := ebmlElement(buf)
+		if hlen == 0 {
+			break
+		}
+		if !ok {
+			// Lost sync.
+			ww.cluster, ww.inCluster = nil, false
+			buf = buf[1:]
+			continue
+		}
+		if ww.inCluster {
+			if webmClusterChildren[id] && size >= 0 {
+				if len(buf) < hlen+size {
+					break
+				}
+				ww.cluster = append(ww.cluster, buf[:hlen+size]...)
+				buf = buf[hlen+size:]
+				continue
+			}
+			ww.Tee.sendUnit(ww.cluster, false)
+			ww.cluster, ww.inCluster = nil, false
+		}
+		switch {
+		case id == ebmlSegment:
+			// Descend into the segment.
+			ww.Tee.sendHeader(append([]byte(nil), buf[:hlen]...), !ww.inHeader)
+			ww.inHeader = true
+			buf = buf[hlen:]
+			continue
+		case id == ebmlCluster && size < 0:
+			ww.inHeader = false
+			ww.inCluster = true
+			ww.cluster = append([]byte(nil), buf[:hlen]...)
+			buf = buf[hlen:]
+			continue
+		case size < 0:
+			// Only segments and clusters can have unknown size.
+			ww.cluster, ww.inCluster = nil, false
+			buf = buf[1:]
+			continue
+		}
+		if len(buf) < hlen+size {
+			break
+		}
+		elt := append([]byte(nil), buf[:hlen+size]...)
+		buf = buf[hlen+size:]
+		switch {
+		case id == ebmlHeader:
+			ww.Tee.sendHeader(elt, true)
+			ww.inHeader = true
+		case id == ebmlCluster:
+			ww.inHeader = false
+			ww.Tee.sendUnit(elt, false)
+		case ww.inHeader:
+			ww.Tee.sendHeader(elt, false)
+		default:
+			ww.Tee.sendUnit(elt, false)
+		}
+	}
+	ww.buf = append(ww.buf[:0], buf...)
+	return len(p), nil
+}
+
+// Parse the ID and size of the EBML element at the start of buf.
+// hlen is the length of the ID and size, or 0 if buf is too short to
+// tell. size is -1 if the element's size is unknown. ok is false if
+// buf doesn't start with a valid element header.
+func ebmlElement(buf []byte) (id uint32, hlen, size int, ok bool) {
+	if len(buf) == 0 {
+		return 0, 0, 0, false
+	}
+	idlen := bits.LeadingZeros8(buf[0]) + 1
+	if idlen > 4 {
+		return 0, 1, 0, false
+	}
+	if len(buf) < idlen+1 {
+		return 0, 0, 0, false
+	}
+	for _, b := range buf[:idlen] {
+		id = id<<8 | uint32(b)
+	}
+	sizelen := bits.LeadingZeros8(buf[idlen]) + 1
+	if sizelen > 8 {
+		return 0, 1, 0, false
+	}
+	if len(buf) < idlen+sizelen {
+		return 0, 0, 0, false
+	}
+	v := uint64(buf[idlen] & (0xff >> sizelen))
+	for _, b := range buf[idlen+1 : idlen+sizelen] {
+		v = v<<8 | uint64(b)
+	}
+	hlen = idlen + sizelen
+	if v == 1<<(7*sizelen)-1 {
+		return id, hlen, -1, true
+	}
+	if v > webmMaxElement {
+		return 0, hlen, 0, false
+	}
+	return id, hlen, int(v), true
+}
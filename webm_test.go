@@ -0,0 +1,64 @@
+package nbtee2
+
+import (
+	"context"
+	"io"
+
+	check "gopkg.in/check.v1"
+)
+
+// Return an EBML element with the given ID and data (which must be
+// less than 127 bytes).
+func ebml(id []byte, data string) []byte {
+	elt := append(append([]byte(nil), id...), 0x80|byte(len(data)))
+	return append(elt, data...)
+}
+
+func (s *Suite) TestWebMWriter(c *check.C) {
+	w := &Tee{}
+	ww := &WebMWriter{Tee: w}
+	idCluster := []byte{0x1f, 0x43, 0xb6, 0x75}
+	header := ebml([]byte{0x1a, 0x45, 0xdf, 0xa3}, "webm")
+	segment := []byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	info := ebml([]byte{0x15, 0x49, 0xa9, 0x66}, "info")
+	tracks := ebml([]byte{0x16, 0x54, 0xae, 0x6b}, "tracks")
+	cluster1 := ebml(idCluster, string(ebml([]byte{0xe7}, "\x00")))
+	cluster2 := concat(idCluster, []byte{0xff}, ebml([]byte{0xe7}, "\x10"), ebml([]byte{0xa3}, "block"))
+	cluster3 := ebml(idCluster, string(ebml([]byte{0xe7}, "\x20")))
+
+	f := &Faults{}
+	r1 := w.newLiveReader(context.Background(), WithFaults(f))
+	stream := concat(header, segment, info, tracks, cluster1)
+	for len(stream) > 0 {
+		n := 3
+		if n > len(stream) {
+			n = len(stream)
+		}
+		ww.Write(stream[:n])
+		stream = stream[n:]
+	}
+	f.Overflow()
+	r2 := w.newLiveReader(context.Background())
+	ww.Write(cluster2)
+	c.Check(w.Stats().Writes, check.Equals, int64(5))
+	ww.Write(cluster3)
+	w.Close()
+
+	for _, trial := range []struct {
+		r     *Reader
+		units [][]byte
+		gaps  []bool
+	}{
+		{r1, [][]byte{cluster2, cluster3}, []bool{true, false}},
+		{r2, [][]byte{header, segment, info, tracks, cluster2, cluster3}, []bool{false, false, false, false, false, false}},
+	} {
+		for i, unit := range trial.units {
+			msg, err := trial.r.ReadMessage()
+			c.Assert(err, check.IsNil)
+			c.Check(msg.Data, check.DeepEquals, unit)
+			c.Check(msg.Gap, check.Equals, trial.gaps[i])
+		}
+		_, err := trial.r.ReadMessage()
+		c.Check(err, check.Equals, io.EOF)
+	}
+}
@@ -3,9 +3,14 @@ package nbtee2
 import (
 	"context"
 	"io"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const defaultHighwater = 64
+
 // Tee is an asynchronous one-to-any pipe. New readers can be added at
 // any time. When a reader isn't reading fast enough to keep up with
 // writes, it misses some data in order to catch up.
@@ -13,33 +18,409 @@ import (
 // Each []byte sent to Write() is either received entirely or not at
 // all by any given reader, assuming it keeps reading until EOF.
 type Tee struct {
-	readers map[*reader]bool
-	mtx     sync.Mutex
+	// Retain, if nonzero, is how long each write is kept after it
+	// is sent, so readers created with NewReaderAt can start in
+	// the past. It should be set before the first call to Write.
+	Retain time.Duration
+
+	// Dedup, if nonzero, suppresses writes whose content (and tag)
+	// is identical to one of the previous Dedup writes. With Dedup
+	// == 1, only consecutive duplicates are suppressed. This is
+	// useful when a producer resends unchanged snapshots at a
+	// fixed interval. It should be set before the first call to
+	// Write.
+	Dedup int
+
+	// FanoutWorkers, if greater than 1, is the number of
+	// goroutines (including the writer's own) that deliver each
+	// write when many readers are attached. This bounds the time
+	// Write takes with thousands of readers, at the cost of some
+	// idle goroutines. The workers exit when the Tee is closed. It
+	// should be set before the first call to Write.
+	FanoutWorkers int
+
+	// MaxBuffered, if nonzero, is a budget for the total size of
+	// the writes waiting in all readers' buffers, counting a write
+	// once for each reader that holds it. When a new write would
+	// exceed the budget, readers' oldest buffered writes are
+	// dropped to make room, starting with the readers with the
+	// lowest priority (see WithPriority).
+	MaxBuffered int64
+
+	readers map[*Reader]bool
+	history []*message
+	header  []*message // replayed to new readers; see sendHeader
+	gen     uint64     // see Restart
+
+	slabMtx sync.Mutex
+	msgSlab []message // see newMessage
+	bufSlab []byte
+	expired time.Time // time of the newest write not retained
+	nextID  uint64
+	started time.Time
+	active  time.Time // last write, or reader added/removed
+	writes  int64
+	bytes   int64
+	dedup   []dedupEntry // ring buffer of recent writes
+	deduped int64
+	fanout  *fanoutPool
+
+	readerDefaults []ReaderOption
+	clock          Clock
+	logger         Logger
+	mtx            sync.Mutex
+}
+
+// A message is a single write, shared (read-only) by all readers
+// and the retention window.
+type message struct {
+	buf []byte
+	t   time.Time
+	tag string
+	seq uint64
+	gen uint64
+	gap bool // upstream gap, see WriteMessage
+
+	header bool // part of the stream header; see sendHeader
+	nosync bool // not a valid place to start or resume reading
+
+	delta     []byte // buf as a delta against an earlier write; see DeltaWriter
+	deltaBase uint64 // seq of the write delta is against
+}
+
+// A Message is a single write, as returned by Reader.ReadMessage.
+type Message struct {
+	// Data is shared with other readers and must not be modified.
+	Data []byte
+
+	// Tag identifies the writer that sent the message (see
+	// Tee.Writer). It is empty for messages sent to Tee.Write.
+	Tag string
+
+	// Time is when the message was written.
+	Time time.Time
+
+	// Seq is the message's sequence number. The first write to a
+	// Tee is 1, the next is 2, and so on.
+	Seq uint64
+
+	// Gap is true if one or more writes were missed just before
+	// this one, either by this reader or (see WriteMessage)
+	// upstream.
+	Gap bool
+
+	// Delta is true if Data is a delta against the previous
+	// message the reader received, rather than the write itself.
+	// See DeltaWriter.
+	Delta bool
+
+	// Generation counts calls to Tee.Restart (and container
+	// streams starting over, see OggWriter) before the message was
+	// written. A change from one message to the next means the
+	// upstream source has restarted.
+	Generation uint64
+}
+
+// A Reader receives a copy of everything sent to a Tee, except for
+// whatever it misses while it isn't keeping up. See NewReaderContext.
+type Reader struct {
+	// Updated atomically; see Stats.
+	bufferedBytes int64
+	dropped       int64
+	droppedBytes  int64
+	lastRead      int64 // UnixNano
+	pendingSince  int64 // UnixNano, time of oldest message in ch (approx)
+	lastWrite     int64 // UnixNano, time of newest message sent to ch
+	delivered     int64 // bytes taken by the consumer
+	waiting       int32 // blocked in fillTodo
+	holding       int32 // len(todo) > 0
+	discard       int32 // set by CloseContext; see discarded
+	lowwater      int32
+
+	id          uint64
+	attached    time.Time
+	ch          chan *message // channel for new writes (guarded by w.mtx)
+	rch         chan *message // channel being read (see SetHighWater)
+	backlog     []*message
+	last        *message // most recent message received into todo
+	lastSeq     uint64
+	gap         bool // there was a gap before r.last
+	synced      bool // at a sync point since the last gap
+	skipped     bool // skipped a gap while waiting for a sync point
+	deltas      bool // accepts deltas; see WithDeltas
+	delta       bool // r.last is delivered as a delta
+	todo        []byte
+	buf         []byte
+	wv          atomic.Value     // *Tee; see tee()
+	gen         uint64           // generation of the last message received
+	pending     []pendingChannel // guarded by w.mtx; see switchChannel
+	highwater   int
+	maxFill     int
+	sampleEvery uint64
+	earlyDrop   float64
+	priority    int
+	paced       bool
+	paceStart   time.Time // when the first write was forwarded
+	paceOrigin  time.Time // timestamp of the first write forwarded
+	faults      *Faults
+	done        chan struct{} // closed by finished(); never replaced
+	doneOnce    sync.Once
+	ctx         context.Context
+}
+
+// A channel that replaced a reader's channel (see SetHighWater and
+// MoveTo) before the consumer switched to it.
+type pendingChannel struct {
+	ch chan *message
+
+	// Set if the reader was moved to another Tee, which had sent
+	// this many writes at the time. Its next write is a gap.
+	moved  bool
+	writes uint64
+}
+
+// A ReaderOption configures a reader created by NewReaderAt.
+type ReaderOption func(*Reader)
+
+// WithWatermarks sets the low and high watermarks described at
+// NewReaderContext. The default is (0, 64).
+func WithWatermarks(lowwater, highwater int) ReaderOption {
+	return func(r *Reader) {
+		r.lowwater = int32(lowwater)
+		r.highwater = highwater
+	}
 }
 
-type reader struct {
-	ch       chan []byte
-	todo     []byte
-	buf      []byte
-	w        *Tee
-	lowwater int
-	ctx      context.Context
+// WithMaxFill limits how much data a reader combines from several
+// writes while waiting for its low watermark: once it has n or more
+// bytes, it stops waiting and returns what it has. This bounds the
+// size of each Read (at n bytes plus one write) and the reader's
+// internal buffer. The default, 0, means no limit.
+func WithMaxFill(n int) ReaderOption {
+	return func(r *Reader) {
+		r.maxFill = n
+	}
+}
+
+// WithSampleEvery makes the reader receive only every n'th write
+// (those whose sequence numbers are multiples of n), for consumers
+// that want a thinned version of a high-rate stream. Skipped writes
+// don't take space in the reader's buffer, and don't count as drops
+// or gaps. Writes that are part of a container stream header (see
+// OggWriter) are never skipped.
+func WithSampleEvery(n int) ReaderOption {
+	return func(r *Reader) {
+		r.sampleEvery = uint64(n)
+	}
+}
+
+// WithEarlyDrop makes the reader start dropping new writes at
+// random once its buffer is more than the given fraction (between 0
+// and 1) full, instead of only when it is completely full. The
+// probability of dropping a write rises linearly from 0 at that
+// fill level to 1 when the buffer is full, like random early
+// detection in network queues. For consumers that tolerate sparse
+// gaps better than long bursts of missing data, this spreads out the
+// losses of a reader that can't keep up.
+func WithEarlyDrop(start float64) ReaderOption {
+	return func(r *Reader) {
+		r.earlyDrop = start
+	}
+}
+
+// WithPacing makes the reader's WriteTo method forward writes no
+// faster than they were originally sent, according to their
+// timestamps. This is useful for replaying retained writes (see
+// NewReaderAt) to a consumer that expects real-time data.
+//
+// A paced reader stays as far behind live writes as it was when it
+// started, so its high watermark must be large enough to buffer
+// that many writes, or it will drop some to catch up.
+func WithPacing() ReaderOption {
+	return func(r *Reader) {
+		r.paced = true
+	}
 }
 
 // Write sends p to all readers that aren't overflowing. Write never
 // blocks. The returned error is always nil.
 func (w *Tee) Write(p []byte) (int, error) {
-	buf := make([]byte, len(p))
-	copy(buf, p)
+	m := w.newMessage(len(p))
+	copy(m.buf, p)
+	w.send(m)
+	return len(p), nil
+}
+
+// TryWrite is like Write, but returns the number of readers that
+// received p and the number that dropped it because their buffers
+// were full. Readers that skip p because of WithSampleEvery are not
+// counted either way. If p is suppressed by Tee.Dedup, both are zero.
+func (w *Tee) TryWrite(p []byte) (delivered, dropped int) {
+	m := w.newMessage(len(p))
+	copy(m.buf, p)
+	return w.send(m)
+}
+
+// WriteString is like Write, but avoids converting s to a []byte
+// before copying it. It implements io.StringWriter.
+func (w *Tee) WriteString(s string) (int, error) {
+	m := w.newMessage(len(s))
+	copy(m.buf, s)
+	w.send(m)
+	return len(s), nil
+}
+
+// WriteFrom reads exactly n bytes from r and sends them to all
+// readers as a single write, like Write. The bytes are read directly
+// into the buffer readers will share, so there is no intermediate
+// copy. If r returns fewer than n bytes, nothing is sent, and the
+// error is returned as from io.ReadFull.
+func (w *Tee) WriteFrom(r io.Reader, n int) error {
+	m := w.newMessage(n)
+	if _, err := io.ReadFull(r, m.buf); err != nil {
+		return err
+	}
+	w.send(m)
+	return nil
+}
+
+// Writer returns an io.Writer that sends each write to w, just like
+// w.Write, tagged with the given tag. Readers can retrieve the tag
+// with ReadMessage. This allows multiple producers writing to the
+// same Tee to be distinguished.
+func (w *Tee) Writer(tag string) io.Writer {
+	return taggedWriter{w, tag}
+}
+
+type taggedWriter struct {
+	w   *Tee
+	tag string
+}
+
+// WriteMessage sends a copy of msg.Data to all readers that aren't
+// overflowing, like Write, with the tag msg.Tag. If msg.Gap is true,
+// readers will see a gap before this message even if they haven't
+// missed anything themselves. Seq and Time are ignored: the Tee
+// assigns them as usual.
+//
+// This is useful for mirroring another Tee; see Decoder.
+func (w *Tee) WriteMessage(msg Message) error {
+	m := w.newMessage(len(msg.Data))
+	copy(m.buf, msg.Data)
+	m.tag, m.gap = msg.Tag, msg.Gap
+	w.send(m)
+	return nil
+}
+
+func (tw taggedWriter) Write(p []byte) (int, error) {
+	m := tw.w.newMessage(len(p))
+	copy(m.buf, p)
+	m.tag = tw.tag
+	tw.w.send(m)
+	return len(p), nil
+}
+
+func (tw taggedWriter) WriteString(s string) (int, error) {
+	m := tw.w.newMessage(len(s))
+	copy(m.buf, s)
+	m.tag = tw.tag
+	tw.w.send(m)
+	return len(s), nil
+}
+
+// Timestamp m and broadcast it. Return the number of readers that
+// received and dropped it.
+func (w *Tee) send(m *message) (delivered, dropped int) {
 	w.mtx.Lock()
 	defer w.mtx.Unlock()
+	if w.Dedup > 0 && w.duplicate(m) {
+		w.deduped++
+		return 0, 0
+	}
+	m.t = w.now()
+	return w.broadcast(m)
+}
+
+// Send m to all readers that aren't overflowing, and add it to the
+// retention window. Return the number of readers that received and
+// dropped it. Caller must have w.mtx.
+func (w *Tee) broadcast(m *message) (delivered, dropped int) {
+	if w.Retain > 0 {
+		w.history = append(w.history, m)
+		w.expire(m.t)
+	} else {
+		w.expired = m.t
+	}
+	if w.started.IsZero() {
+		w.started = m.t
+	}
+	w.active = m.t
+	w.writes++
+	w.bytes += int64(len(m.buf))
+	m.seq = uint64(w.writes)
+	m.gen = w.gen
+	if w.MaxBuffered > 0 {
+		w.reclaim(m)
+	}
+	if w.FanoutWorkers > 1 && len(w.readers) >= fanoutMinReaders {
+		return w.fanoutBroadcast(m)
+	}
 	for r := range w.readers {
-		select {
-		case r.ch <- buf:
-		default:
+		switch r.offer(m) {
+		case offerDelivered:
+			delivered++
+		case offerDropped:
+			dropped++
 		}
 	}
-	return len(p), nil
+	return
+}
+
+// The outcome of offering a message to a reader.
+type offerResult int
+
+const (
+	offerDelivered offerResult = iota
+	offerSkipped               // the reader doesn't want it (see WithSampleEvery)
+	offerDropped
+)
+
+// Send m to r if r's buffer isn't full, otherwise count it as
+// dropped.
+func (r *Reader) offer(m *message) offerResult {
+	if !r.sampled(m) {
+		// Not dropped: the reader didn't want it.
+		return offerSkipped
+	}
+	if r.earlyDrop > 0 && r.dropEarly() {
+		r.drop(m)
+		return offerDropped
+	}
+	select {
+	case r.ch <- m:
+		atomic.AddInt64(&r.bufferedBytes, int64(len(m.buf)))
+		atomic.StoreInt64(&r.lastWrite, m.t.UnixNano())
+		if r.buffered() == 1 {
+			atomic.StoreInt64(&r.pendingSince, m.t.UnixNano())
+		}
+		return offerDelivered
+	default:
+		r.drop(m)
+		return offerDropped
+	}
+}
+
+// Drop messages that have been retained longer than w.Retain as of
+// now. Caller must have w.mtx.
+func (w *Tee) expire(now time.Time) {
+	cutoff := now.Add(-w.Retain)
+	i := 0
+	for i < len(w.history) && w.history[i].t.Before(cutoff) {
+		w.expired = w.history[i].t
+		w.history[i] = nil
+		i++
+	}
+	w.history = w.history[i:]
 }
 
 // Close causes all readers to reach EOF when they finish reading
@@ -47,14 +428,25 @@ func (w *Tee) Write(p []byte) (int, error) {
 func (w *Tee) Close() error {
 	w.mtx.Lock()
 	defer w.mtx.Unlock()
+	w.closeLocked()
+	return nil
+}
+
+// Detach all readers, and return them. Caller must have w.mtx.
+func (w *Tee) closeLocked() []*Reader {
+	readers := make([]*Reader, 0, len(w.readers))
 	for r := range w.readers {
 		close(r.ch)
+		readers = append(readers, r)
 	}
 	w.readers = nil
-	return nil
+	w.history = nil
+	w.header = nil
+	w.stopFanout()
+	return readers
 }
 
-// NewReaderContext returns a new io.ReadCloser that reads a copy of
+// NewReaderContext returns a new Reader that reads a copy of
 // everything sent to Write(), dropping all buffered writes in order
 // to catch up whenever it falls behind by `highwater` writes.
 //
@@ -64,86 +456,614 @@ func (w *Tee) Close() error {
 // It is safe to call the reader's Close() method while a Read() is in
 // progress, and after calling Close(), it is safe (but unnecessary)
 // to call Read() until EOF.
-func (w *Tee) NewReaderContext(ctx context.Context, lowwater, highwater int) io.ReadCloser {
+func (w *Tee) NewReaderContext(ctx context.Context, lowwater, highwater int) *Reader {
+	return w.newLiveReader(ctx, WithWatermarks(lowwater, highwater))
+}
+
+// NewReaderAt returns a new Reader that starts by reading
+// the retained writes that were sent at or after start, then
+// continues with new writes as described at NewReaderContext.
+//
+// If start is older than the retention window (see Tee.Retain), the
+// reader starts with the oldest retained write. If start is in the
+// future, or nothing is retained, the reader starts with the next
+// write.
+//
+// Retained writes are delivered regardless of the reader's high
+// watermark.
+func (w *Tee) NewReaderAt(ctx context.Context, start time.Time, opts ...ReaderOption) *Reader {
+	r := w.newReader(ctx, opts...)
 	w.mtx.Lock()
 	defer w.mtx.Unlock()
-	ch := make(chan []byte, highwater)
-	r := &reader{ch: ch, w: w, lowwater: lowwater, ctx: ctx}
+	w.addReaderAt(r, start)
+	return r
+}
+
+// NewReaderSince is like NewReaderAt, except that if any writes sent
+// at or after t are no longer retained (or nothing is retained, and
+// something was written since t), the first message the reader
+// receives has Gap set. This suits consumers that want everything
+// since they asked for it (at time t) but might attach a little
+// later, and need to know whether they got it all.
+func (w *Tee) NewReaderSince(ctx context.Context, t time.Time, opts ...ReaderOption) *Reader {
+	r := w.newReader(ctx, opts...)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.addReaderAt(r, t)
+	if !w.expired.IsZero() && !w.expired.Before(t) {
+		// Make the next message look out of sequence.
+		r.lastSeq--
+	}
+	return r
+}
+
+// Snapshot returns a copy of each write currently in the retention
+// window (see Tee.Retain), oldest first. This is useful for serving
+// the current state to a request/response client without attaching
+// a reader.
+func (w *Tee) Snapshot() [][]byte {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.Retain > 0 {
+		w.expire(w.now())
+	}
+	snap := make([][]byte, len(w.history))
+	for i, m := range w.history {
+		snap[i] = append([]byte(nil), m.buf...)
+	}
+	return snap
+}
+
+// Attach r, starting with the retained writes sent at or after
+// start. Caller must have w.mtx.
+func (w *Tee) addReaderAt(r *Reader, start time.Time) {
+	if w.Retain > 0 {
+		w.expire(w.now())
+	}
+	i := len(w.history)
+	for i > 0 && !w.history[i-1].t.Before(start) {
+		i--
+	}
+	for _, m := range w.history[i:] {
+		if !m.header || len(w.header) == 0 {
+			r.backlog = append(r.backlog, m)
+		}
+	}
+	w.addReader(r)
+	if i < len(w.history) {
+		r.lastSeq = w.history[i].seq - 1
+	}
+}
+
+func (w *Tee) newReader(ctx context.Context, opts ...ReaderOption) *Reader {
+	r := &Reader{highwater: defaultHighwater, ctx: ctx, lastRead: w.now().UnixNano()}
+	r.wv.Store(w)
+	for _, opt := range w.readerDefaults {
+		opt(r)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.ch = make(chan *message, r.highwater)
+	r.rch = r.ch
+	r.done = make(chan struct{})
+	return r
+}
+
+// NewLiveReader returns a reader configured with opts, starting with
+// the next write. This is the kind of reader Server and StreamHandler
+// create for each client, so adapters that serve a Tee over another
+// transport can do the same.
+func (w *Tee) NewLiveReader(ctx context.Context, opts ...ReaderOption) *Reader {
+	return w.newLiveReader(ctx, opts...)
+}
+
+// Create a reader and attach it, starting with the next write.
+func (w *Tee) newLiveReader(ctx context.Context, opts ...ReaderOption) *Reader {
+	r := w.newReader(ctx, opts...)
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.addReader(r)
+	return r
+}
+
+// Caller must have w.mtx.
+func (w *Tee) addReader(r *Reader) {
 	if w.readers == nil {
-		w.readers = make(map[*reader]bool, 1)
+		w.readers = make(map[*Reader]bool, 1)
 	}
+	w.nextID++
+	r.id = w.nextID
 	w.readers[r] = true
-	return r
+	w.active = w.now()
+	r.attached = w.active
+	r.lastSeq = uint64(w.writes)
+	if len(w.header) > 0 {
+		r.backlog = append(append([]*message(nil), w.header...), r.backlog...)
+	}
 }
 
 // NewReader calls NewReaderContext with context.Background().
-func (w *Tee) NewReader(lowwater, highwater int) io.ReadCloser {
+func (w *Tee) NewReader(lowwater, highwater int) *Reader {
 	return w.NewReaderContext(context.Background(), lowwater, highwater)
 }
 
-func (r *reader) WriteTo(w io.Writer) (n int64, err error) {
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
 	defer r.Close()
 	for err == nil {
 		err = r.fillTodo()
 		if len(r.todo) == 0 {
 			continue
 		}
+		if r.paced {
+			if err = r.pace(); err != nil {
+				break
+			}
+		}
 		var nn int
 		nn, err = w.Write(r.todo)
 		n += int64(nn)
 		r.todo = r.todo[nn:]
+		r.consumed(nn)
 	}
 	return
 }
 
+// Wait until r.last is due to be forwarded, according to the time
+// between it and the first write forwarded.
+func (r *Reader) pace() error {
+	w := r.tee()
+	if r.paceStart.IsZero() {
+		r.paceStart, r.paceOrigin = w.now(), r.last.t
+		return nil
+	}
+	d := r.paceStart.Add(r.last.t.Sub(r.paceOrigin)).Sub(w.now())
+	if d <= 0 {
+		return nil
+	}
+	// Not stalled: holding data on purpose.
+	atomic.StoreInt32(&r.waiting, 1)
+	defer atomic.StoreInt32(&r.waiting, 0)
+	return w.sleep(r.ctx, d)
+}
+
 // Read implements io.Reader.
-func (r *reader) Read(p []byte) (int, error) {
+func (r *Reader) Read(p []byte) (int, error) {
 	err := r.fillTodo()
 	n := copy(p, r.todo)
 	r.todo = r.todo[n:]
+	r.consumed(n)
 	return n, err
 }
 
+// ReadSlice returns the next buffered data without copying it into a
+// caller-provided slice, combining writes according to the low
+// watermark just as Read does. The returned slice must not be
+// modified, and is only valid until the next call to a read method.
+//
+// At EOF, ReadSlice returns nil, io.EOF.
+func (r *Reader) ReadSlice() ([]byte, error) {
+	err := r.fillTodo()
+	p := r.todo
+	r.todo = nil
+	r.consumed(len(p))
+	if len(p) > 0 {
+		return p, nil
+	}
+	return nil, err
+}
+
+// ReadBatch returns the next write and up to max-1 more that are
+// ready without waiting, preserving write boundaries (like repeated
+// calls to ReadMessage). If max <= 0, it returns all the writes that
+// are ready. The returned slices are shared with other readers and
+// must not be modified.
+//
+// At EOF, ReadBatch returns nil, io.EOF.
+func (r *Reader) ReadBatch(max int) ([][]byte, error) {
+	msg, err := r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	batch := [][]byte{msg.Data}
+	for max <= 0 || len(batch) < max {
+		msg, ok := r.poll()
+		if !ok {
+			break
+		}
+		batch = append(batch, msg.Data)
+	}
+	return batch, nil
+}
+
+// Record that the consumer has taken n bytes.
+func (r *Reader) consumed(n int) {
+	atomic.AddInt64(&r.delivered, int64(n))
+	r.progress()
+}
+
+// Record that the consumer has received data.
+func (r *Reader) progress() {
+	atomic.StoreInt64(&r.lastRead, r.tee().now().UnixNano())
+	if len(r.todo) > 0 {
+		atomic.StoreInt32(&r.holding, 1)
+	} else {
+		atomic.StoreInt32(&r.holding, 0)
+	}
+}
+
+// Return how long the reader has had data pending without the
+// consumer making progress, or 0 if it isn't stalled.
+func (r *Reader) stalled(now time.Time) time.Duration {
+	if atomic.LoadInt32(&r.waiting) != 0 {
+		return 0
+	}
+	since := atomic.LoadInt64(&r.lastRead)
+	if atomic.LoadInt32(&r.holding) == 0 {
+		if r.buffered() == 0 {
+			return 0
+		}
+		if t := atomic.LoadInt64(&r.pendingSince); t > since {
+			since = t
+		}
+	}
+	if d := now.Sub(time.Unix(0, since)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// ReadMessage returns the next write, without combining it with
+// other writes (regardless of the low watermark). If a previous call
+// to Read returned only part of a write, ReadMessage returns the rest
+// of it.
+//
+// At EOF, ReadMessage returns io.EOF.
+func (r *Reader) ReadMessage() (Message, error) {
+	if r.discarded() {
+		return Message{}, io.EOF
+	}
+	if len(r.todo) == 0 {
+		m, err := r.next()
+		if err != nil {
+			return Message{}, err
+		}
+		r.todo = r.data(m)
+	}
+	return r.takeMessage(), nil
+}
+
+// Return r.todo as a Message from r.last, and mark it consumed.
+func (r *Reader) takeMessage() Message {
+	msg := Message{
+		Data:  r.todo,
+		Tag:   r.last.tag,
+		Time:  r.last.t,
+		Seq:   r.last.seq,
+		Gap:   r.gap,
+		Delta: r.delta,
+
+		Generation: r.last.gen,
+	}
+	r.todo = nil
+	r.consumed(len(msg.Data))
+	return msg
+}
+
 // Fill r.todo with the next incoming buf. If an incoming buf isn't
 // ready, block until r.lowwater buffers have been read into r.todo or
 // r.ctx is cancelled.
-func (r *reader) fillTodo() (err error) {
+func (r *Reader) fillTodo() (err error) {
+	if r.discarded() {
+		return io.EOF
+	}
 	if len(r.todo) > 0 {
 		return nil
 	}
+	if m := r.popBacklog(); m != nil {
+		r.todo = r.data(m)
+		r.progress()
+		return nil
+	}
 	lowwater := 1
-	if r.lowwater > 1 && len(r.ch) == 0 {
-		lowwater = r.lowwater
+	if lw := int(atomic.LoadInt32(&r.lowwater)); lw > 1 && len(r.rch) == 0 {
+		lowwater = lw
+	}
+	// Message bufs are never modified after they are sent, so a
+	// single message can be handed to the consumer without copying.
+	// Only messages combined to satisfy lowwater are copied into
+	// r.buf.
+	var todo []byte
+	for i := 0; i < lowwater && (r.maxFill == 0 || len(todo) < r.maxFill); i++ {
+		var m *message
+		m, err = r.recv()
+		if err != nil {
+			break
+		}
+		if i == 0 {
+			todo = r.data(m)
+			continue
+		}
+		if i == 1 {
+			r.buf = append(r.buf[:0], todo...)
+		}
+		r.buf = append(r.buf, r.data(m)...)
+		todo = r.buf
+	}
+	r.catchUp()
+	r.todo = todo
+	r.progress()
+	return
+}
+
+// Return the next message from the backlog, or (if the backlog is
+// empty) the channel.
+func (r *Reader) next() (*message, error) {
+	if m := r.popBacklog(); m != nil {
+		return m, nil
+	}
+	m, err := r.recv()
+	if err == nil {
+		r.catchUp()
+	}
+	return m, err
+}
+
+// Return the next message from the backlog, or nil if the backlog
+// is empty.
+func (r *Reader) popBacklog() *message {
+	for len(r.backlog) > 0 {
+		m := r.backlog[0]
+		r.backlog[0] = nil
+		r.backlog = r.backlog[1:]
+		if !r.sampled(m) {
+			continue
+		}
+		if r.received(m) {
+			return m
+		}
+		r.drop(m)
+	}
+	return nil
+}
+
+// Wait for the next message from the channel.
+func (r *Reader) recv() (*message, error) {
+	if r.faults != nil {
+		if err := r.faults.before(r); err != nil {
+			return nil, err
+		}
 	}
-	r.buf = r.buf[:0]
-	for i := 0; i < lowwater && err == nil; i++ {
+	atomic.StoreInt32(&r.waiting, 1)
+	defer atomic.StoreInt32(&r.waiting, 0)
+	for {
 		select {
-		case buf, ok := <-r.ch:
+		case m, ok := <-r.rch:
 			if !ok {
-				err = io.EOF
-			} else {
-				r.buf = append(r.buf, buf...)
+				if r.switchChannel() {
+					continue
+				}
+				r.finished()
+				return nil, io.EOF
+			}
+			atomic.AddInt64(&r.bufferedBytes, -int64(len(m.buf)))
+			if r.discarded() {
+				r.drop(m)
+				return nil, io.EOF
 			}
+			if !r.received(m) {
+				r.drop(m)
+				continue
+			}
+			return m, nil
 		case <-r.ctx.Done():
-			err = r.ctx.Err()
+			return nil, r.ctx.Err()
 		}
 	}
-	if cap(r.ch) > 2 && len(r.ch) >= cap(r.ch)-1 {
-		for len(r.ch) > 0 {
-			<-r.ch
+}
+
+// After r.rch is closed and drained, switch to the channel that
+// replaced it (see SetHighWater) and return true, or return false if
+// r is closed. If the channel was replaced more than once, the
+// replacements are read in order, so nothing buffered in them is
+// lost.
+func (r *Reader) switchChannel() bool {
+	w := r.lockTee()
+	defer w.mtx.Unlock()
+	if len(r.pending) == 0 {
+		return false
+	}
+	p := r.pending[0]
+	r.pending[0] = pendingChannel{}
+	r.pending = r.pending[1:]
+	r.rch = p.ch
+	if p.moved {
+		// See MoveTo.
+		r.lastSeq = p.writes
+		r.skipped = true
+	}
+	return true
+}
+
+// Return the number of writes buffered in r's channels: r.rch, and
+// any channels that replaced it. Caller must have w.mtx.
+func (r *Reader) buffered() int {
+	n := len(r.rch)
+	for _, p := range r.pending {
+		n += len(p.ch)
+	}
+	return n
+}
+
+// Replace r.ch with a new channel of size n, which the consumer
+// switches to after reading what's in the old one. Caller must have
+// w.mtx.
+func (r *Reader) replaceChannel(n int, p pendingChannel) {
+	old := r.ch
+	r.ch = make(chan *message, n)
+	p.ch = r.ch
+	r.pending = append(r.pending, p)
+	close(old)
+}
+
+// Update r.last, r.lastSeq, and r.gap after receiving m.
+//
+// Return false if m should be skipped because it isn't a sync point
+// and the reader is just starting or has just missed something.
+func (r *Reader) received(m *message) bool {
+	if len(r.rch) > 0 {
+		// The next message in the channel was written at or
+		// after m.
+		atomic.StoreInt64(&r.pendingSince, m.t.UnixNano())
+	}
+	if m.header {
+		if m.seq == r.lastSeq+1 {
+			// Received live, not replayed.
+			r.lastSeq = m.seq
 		}
+		r.last = m
+		r.gap = false
+		r.delta = false
+		return true
 	}
-	r.todo = r.buf
-	return
+	if m.gen != r.gen {
+		// Wait for a sync point in the new generation.
+		r.gen = m.gen
+		r.synced = false
+	}
+	gap := m.gap || m.seq != r.nextSeq() || r.skipped
+	r.lastSeq = m.seq
+	if gap {
+		r.synced = false
+	}
+	if m.nosync && !r.synced {
+		r.skipped = gap
+		return false
+	}
+	r.synced = true
+	r.skipped = false
+	// Send a delta only if the consumer has just received the
+	// write it's against.
+	r.delta = r.deltas && m.delta != nil && !gap && r.last != nil && r.last.seq == m.deltaBase
+	r.gap = gap
+	r.last = m
+	return true
+}
+
+// Return true if a new write should be dropped because of
+// WithEarlyDrop. Caller must have w.mtx.
+func (r *Reader) dropEarly() bool {
+	if cap(r.ch) == 0 {
+		return false
+	}
+	fill := float64(len(r.ch)) / float64(cap(r.ch))
+	if fill <= r.earlyDrop {
+		return false
+	}
+	return rand.Float64() < (fill-r.earlyDrop)/(1-r.earlyDrop)
+}
+
+// Return false if r skips m because of WithSampleEvery.
+func (r *Reader) sampled(m *message) bool {
+	return r.sampleEvery <= 1 || m.header || m.seq%r.sampleEvery == 0
+}
+
+// Return the sequence number of the next write r should receive if
+// it isn't missing anything.
+func (r *Reader) nextSeq() uint64 {
+	if r.sampleEvery > 1 {
+		return (r.lastSeq/r.sampleEvery + 1) * r.sampleEvery
+	}
+	return r.lastSeq + 1
+}
+
+// Return the data to deliver for m, which must be r.last.
+func (r *Reader) data(m *message) []byte {
+	if r.delta {
+		return m.delta
+	}
+	return m.buf
+}
+
+// If the channel is nearly full, drop everything in it.
+func (r *Reader) catchUp() {
+	if cap(r.rch) > 2 && len(r.rch) >= cap(r.rch)-1 {
+		for len(r.rch) > 0 {
+			if m, ok := <-r.rch; ok {
+				atomic.AddInt64(&r.bufferedBytes, -int64(len(m.buf)))
+				r.drop(m)
+			}
+		}
+	}
+}
+
+// If CloseContext gave up waiting for r to finish, drop whatever the
+// consumer hasn't read yet, and return true. The channels are closed
+// and drained by CloseContext.
+func (r *Reader) discarded() bool {
+	if atomic.LoadInt32(&r.discard) == 0 {
+		return false
+	}
+	for _, m := range r.backlog {
+		r.drop(m)
+	}
+	r.backlog = nil
+	r.todo = nil
+	atomic.StoreInt32(&r.holding, 0)
+	r.finished()
+	return true
+}
+
+// Count m as dropped.
+func (r *Reader) drop(m *message) {
+	atomic.AddInt64(&r.dropped, 1)
+	atomic.AddInt64(&r.droppedBytes, int64(len(m.buf)))
+}
+
+// SetLowWater changes the reader's low watermark (see
+// NewReaderContext). It is safe to call while the reader is in use,
+// and takes effect the next time the reader waits for data.
+func (r *Reader) SetLowWater(n int) {
+	atomic.StoreInt32(&r.lowwater, int32(n))
+}
+
+// SetHighWater changes the size of the reader's buffer (see
+// NewReaderContext) without detaching it. It is safe to call while
+// the reader is in use. Writes already buffered are still delivered,
+// in order, before writes that arrive after the change, even if
+// there are more of them than the new high watermark.
+func (r *Reader) SetHighWater(n int) {
+	w := r.lockTee()
+	defer w.mtx.Unlock()
+	if !w.readers[r] || n == r.highwater {
+		return
+	}
+	// The consumer finishes reading the old channel, then sees it
+	// closed and switches to the new one.
+	r.replaceChannel(n, pendingChannel{})
+	r.highwater = n
 }
 
 // Close releases resources. Readers should be closed after use.
-func (r *reader) Close() error {
-	r.w.mtx.Lock()
-	defer r.w.mtx.Unlock()
-	if r.w.readers[r] {
+func (r *Reader) Close() error {
+	r.detach()
+	r.finished()
+	return nil
+}
+
+// Stop sending new writes to r. The consumer still gets what's
+// buffered, then EOF.
+func (r *Reader) detach() {
+	w := r.lockTee()
+	defer w.mtx.Unlock()
+	if w.readers[r] {
 		close(r.ch)
-		delete(r.w.readers, r)
+		delete(w.readers, r)
+		w.active = w.now()
 	}
-	return nil
+}
+
+// Record that the consumer has reached EOF or given up.
+func (r *Reader) finished() {
+	r.doneOnce.Do(func() { close(r.done) })
 }
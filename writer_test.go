@@ -1,9 +1,14 @@
 package nbtee2
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"io/ioutil"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	check "gopkg.in/check.v1"
 )
@@ -56,3 +61,477 @@ func (s *Suite) TestWriter(c *check.C) {
 	c.Check(w.Close(), check.IsNil)
 	wg.Wait()
 }
+
+func (s *Suite) TestNewReaderAt(c *check.C) {
+	w := &Tee{Retain: time.Minute}
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	w.Write([]byte{3})
+	now := time.Now()
+	w.history[0].t = now.Add(-2 * time.Minute)
+	w.history[1].t = now.Add(-40 * time.Second)
+	w.history[2].t = now.Add(-20 * time.Second)
+
+	r30 := w.NewReaderAt(context.Background(), now.Add(-30*time.Second))
+	rOld := w.NewReaderAt(context.Background(), now.Add(-time.Hour))
+	rLive := w.NewReaderAt(context.Background(), now.Add(time.Hour))
+	w.Write([]byte{4})
+	w.Close()
+
+	buf, err := ioutil.ReadAll(r30)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.DeepEquals, []byte{3, 4})
+	buf, err = ioutil.ReadAll(rOld)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.DeepEquals, []byte{2, 3, 4})
+	buf, err = ioutil.ReadAll(rLive)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.DeepEquals, []byte{4})
+}
+
+func (s *Suite) TestSnapshot(c *check.C) {
+	w := &Tee{}
+	w.Write([]byte{1})
+	c.Check(w.Snapshot(), check.HasLen, 0)
+
+	w = &Tee{Retain: time.Minute}
+	w.Write([]byte{1})
+	w.Write([]byte{2, 3})
+	w.Write([]byte{4})
+	w.history[0].t = time.Now().Add(-2 * time.Minute)
+	snap := w.Snapshot()
+	c.Check(snap, check.DeepEquals, [][]byte{{2, 3}, {4}})
+	// The snapshot is a copy.
+	snap[0][0] = 9
+	c.Check(w.Snapshot()[0], check.DeepEquals, []byte{2, 3})
+}
+
+func (s *Suite) TestNewReaderSince(c *check.C) {
+	w := &Tee{Retain: time.Minute}
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	now := time.Now()
+	w.history[0].t = now.Add(-2 * time.Minute)
+	w.history[1].t = now.Add(-20 * time.Second)
+	w.expire(now)
+
+	gaps := func(r *Reader) (got []bool) {
+		for {
+			msg, err := r.ReadMessage()
+			if err != nil {
+				return
+			}
+			got = append(got, msg.Gap)
+		}
+	}
+	// Write 1 was sent after the requested time, but has expired.
+	rMissed := w.NewReaderSince(context.Background(), now.Add(-3*time.Minute))
+	rComplete := w.NewReaderSince(context.Background(), now.Add(-time.Minute))
+	rLive := w.NewReaderSince(context.Background(), now)
+	w.Write([]byte{3})
+	w.Close()
+	c.Check(gaps(rMissed), check.DeepEquals, []bool{true, false})
+	c.Check(gaps(rComplete), check.DeepEquals, []bool{false, false})
+	c.Check(gaps(rLive), check.DeepEquals, []bool{false})
+
+	// Without retention, anything written since t is missed.
+	w = &Tee{}
+	t := time.Now()
+	w.Write([]byte{1})
+	r := w.NewReaderSince(context.Background(), t)
+	w.Write([]byte{2})
+	w.Close()
+	c.Check(gaps(r), check.DeepEquals, []bool{true})
+}
+
+func (s *Suite) TestWriterTags(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(4, 16)
+	a, b := w.Writer("a"), w.Writer("b")
+	a.Write([]byte{1})
+	b.Write([]byte{2, 3})
+	w.Write([]byte{4})
+	a.Write([]byte{5, 6})
+	w.Close()
+
+	buf := make([]byte, 1)
+	n, err := r.Read(buf)
+	c.Check(n, check.Equals, 1)
+	c.Check(err, check.IsNil)
+	var got []Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Time.IsZero(), check.Equals, false)
+		msg.Time = time.Time{}
+		got = append(got, msg)
+	}
+	c.Check(got, check.DeepEquals, []Message{
+		{Data: []byte{2, 3}, Tag: "b", Seq: 2},
+		{Data: []byte{4}, Seq: 3},
+		{Data: []byte{5, 6}, Tag: "a", Seq: 4},
+	})
+}
+
+func (s *Suite) TestReadMessageGap(c *check.C) {
+	w := &Tee{}
+	w.Write([]byte{0})
+	r := w.NewReader(0, 2)
+	type result struct {
+		seq uint64
+		gap bool
+	}
+	var got []result
+	read := func() {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Data, check.DeepEquals, []byte{byte(msg.Seq - 1)})
+		got = append(got, result{msg.Seq, msg.Gap})
+	}
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	w.Write([]byte{3}) // dropped
+	read()
+	read()
+	w.Write([]byte{4})
+	read()
+	w.WriteMessage(Message{Data: []byte{5}, Gap: true})
+	read()
+	w.Write([]byte{6})
+	read()
+	c.Check(got, check.DeepEquals, []result{
+		{2, false},
+		{3, false},
+		{5, true},
+		{6, true},
+		{7, false},
+	})
+}
+
+func (s *Suite) TestWriteString(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	var _ io.StringWriter = w
+	io.WriteString(w, "foo")
+	io.WriteString(w.Writer("t"), "bar")
+	w.Close()
+	msg, err := r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "foo")
+	msg, err = r.ReadMessage()
+	c.Check(err, check.IsNil)
+	c.Check(string(msg.Data), check.Equals, "bar")
+	c.Check(msg.Tag, check.Equals, "t")
+}
+
+func (s *Suite) TestReadersShareBufs(c *check.C) {
+	w := &Tee{}
+	r1 := w.NewReader(0, 4)
+	r2 := w.NewReader(2, 4)
+	w.Write([]byte{1, 2, 3})
+	c.Assert(r1.fillTodo(), check.IsNil)
+	c.Assert(r2.fillTodo(), check.IsNil)
+	// A single write is handed out without copying.
+	c.Check(&r1.todo[0] == &r2.todo[0], check.Equals, true)
+
+	// Writes combined to satisfy lowwater are copied.
+	r2.todo = nil
+	go func() {
+		for atomic.LoadInt32(&r2.waiting) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.Write([]byte{4})
+		w.Write([]byte{5})
+	}()
+	c.Assert(r2.fillTodo(), check.IsNil)
+	c.Check(r2.todo, check.DeepEquals, []byte{4, 5})
+	w.Close()
+}
+
+func (s *Suite) TestReadSlice(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	w.Write([]byte{1, 2, 3})
+	w.Write([]byte{4})
+	w.Close()
+	buf := make([]byte, 2)
+	n, err := r.Read(buf)
+	c.Check(n, check.Equals, 2)
+	c.Check(err, check.IsNil)
+	// The rest of a partially read write comes first.
+	p, err := r.ReadSlice()
+	c.Check(p, check.DeepEquals, []byte{3})
+	c.Check(err, check.IsNil)
+	p, err = r.ReadSlice()
+	c.Check(p, check.DeepEquals, []byte{4})
+	c.Check(err, check.IsNil)
+	p, err = r.ReadSlice()
+	c.Check(p, check.IsNil)
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestReadBatch(c *check.C) {
+	w := &Tee{}
+	r := w.newLiveReader(context.Background())
+	for i := byte(1); i <= 5; i++ {
+		w.Write([]byte{i, i})
+	}
+	batch, err := r.ReadBatch(3)
+	c.Check(err, check.IsNil)
+	c.Check(batch, check.DeepEquals, [][]byte{{1, 1}, {2, 2}, {3, 3}})
+	batch, err = r.ReadBatch(0)
+	c.Check(err, check.IsNil)
+	c.Check(batch, check.DeepEquals, [][]byte{{4, 4}, {5, 5}})
+	w.Close()
+	batch, err = r.ReadBatch(3)
+	c.Check(batch, check.IsNil)
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestReadBatchSkipped(c *check.C) {
+	w := &Tee{}
+	r := w.newLiveReader(context.Background())
+	w.sendUnit([]byte("k"), false)
+	w.send(&message{buf: []byte("d"), nosync: true, gap: true})
+	batch, err := r.ReadBatch(10)
+	c.Check(err, check.IsNil)
+	c.Check(batch, check.DeepEquals, [][]byte{[]byte("k")})
+
+	// Skipped writes in the backlog don't block either.
+	w = &Tee{Retain: time.Minute}
+	for i := byte(1); i <= 4; i++ {
+		w.Write([]byte{i})
+	}
+	r = w.NewReaderAt(context.Background(), time.Time{}, WithSampleEvery(2))
+	batch, err = r.ReadBatch(10)
+	c.Check(err, check.IsNil)
+	c.Check(batch, check.DeepEquals, [][]byte{{2}, {4}})
+}
+
+func (s *Suite) TestMaxFill(c *check.C) {
+	w := &Tee{}
+	r := w.newLiveReader(context.Background(), WithWatermarks(10, 64), WithMaxFill(4))
+	go func() {
+		for atomic.LoadInt32(&r.waiting) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.Write([]byte{1, 2})
+		w.Write([]byte{3, 4})
+		w.Write([]byte{5, 6})
+		w.Close()
+	}()
+	p, err := r.ReadSlice()
+	c.Check(err, check.IsNil)
+	c.Check(p, check.DeepEquals, []byte{1, 2, 3, 4})
+	buf, err := ioutil.ReadAll(r)
+	c.Check(err, check.IsNil)
+	c.Check(buf, check.DeepEquals, []byte{5, 6})
+}
+
+func (s *Suite) TestSampleEvery(c *check.C) {
+	w := &Tee{}
+	w.Write([]byte{1})
+	f := &Faults{}
+	r := w.newLiveReader(context.Background(), WithSampleEvery(3), WithFaults(f))
+	for i := byte(2); i <= 10; i++ {
+		w.Write([]byte{i})
+	}
+	c.Check(w.Stats().Readers[0].Buffered, check.Equals, 3)
+	f.Overflow()
+	for i := byte(11); i <= 15; i++ {
+		w.Write([]byte{i})
+	}
+	w.Close()
+	var got []Message
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			c.Check(err, check.Equals, io.EOF)
+			break
+		}
+		got = append(got, msg)
+	}
+	c.Assert(got, check.HasLen, 2)
+	c.Check(got[0].Data, check.DeepEquals, []byte{12})
+	c.Check(got[0].Gap, check.Equals, true)
+	c.Check(got[1].Data, check.DeepEquals, []byte{15})
+	c.Check(got[1].Gap, check.Equals, false)
+	c.Check(r.CloseWithStats().Dropped, check.Equals, int64(3))
+}
+
+func (s *Suite) TestSampleEveryNoGaps(c *check.C) {
+	w := &Tee{}
+	r := w.newLiveReader(context.Background(), WithSampleEvery(2))
+	for i := byte(1); i <= 6; i++ {
+		w.Write([]byte{i})
+	}
+	w.Close()
+	for _, expect := range []byte{2, 4, 6} {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Data, check.DeepEquals, []byte{expect})
+		c.Check(msg.Gap, check.Equals, false)
+	}
+	_, err := r.ReadMessage()
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestEarlyDrop(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	r := w.newLiveReader(context.Background(), WithWatermarks(0, 100), WithEarlyDrop(0.5))
+	defer r.Close()
+	for i := 0; i < 100; i++ {
+		w.Write([]byte{1})
+	}
+	rs := w.Stats().Readers[0]
+	c.Check(rs.Buffered >= 50, check.Equals, true)
+	c.Check(rs.Buffered < 100, check.Equals, true)
+	c.Check(rs.Dropped, check.Equals, int64(100-rs.Buffered))
+}
+
+func (s *Suite) TestPacedWriteTo(c *check.C) {
+	w := &Tee{Retain: time.Minute}
+	for i := 0; i < 3; i++ {
+		w.Write([]byte{byte(i)})
+	}
+	now := time.Now()
+	for i, m := range w.history {
+		m.t = now.Add(time.Duration(i-10) * 30 * time.Millisecond)
+	}
+	r := w.NewReaderAt(context.Background(), time.Time{}, WithPacing())
+	w.Close()
+	var times []time.Time
+	n, err := r.WriteTo(writerFunc(func(p []byte) (int, error) {
+		times = append(times, time.Now())
+		return len(p), nil
+	}))
+	c.Check(err, check.Equals, io.EOF)
+	c.Check(n, check.Equals, int64(3))
+	c.Assert(times, check.HasLen, 3)
+	for i := 1; i < len(times); i++ {
+		c.Check(times[i].Sub(times[i-1]) >= 25*time.Millisecond, check.Equals, true)
+	}
+}
+
+func (s *Suite) TestWriteFrom(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	src := bytes.NewBufferString("abcdefg")
+	c.Check(w.WriteFrom(src, 3), check.IsNil)
+	c.Check(w.WriteFrom(src, 3), check.IsNil)
+	c.Check(w.WriteFrom(src, 3), check.Equals, io.ErrUnexpectedEOF)
+	c.Check(w.WriteFrom(src, 3), check.Equals, io.EOF)
+	w.Close()
+	var got []string
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			break
+		}
+		got = append(got, string(msg.Data))
+	}
+	c.Check(got, check.DeepEquals, []string{"abc", "def"})
+}
+
+func (s *Suite) TestSetHighWater(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 2)
+	w.Write([]byte{1})
+	w.Write([]byte{2})
+	r.SetHighWater(8)
+	for i := 3; i <= 6; i++ {
+		w.Write([]byte{byte(i)})
+	}
+	var seqs []uint64
+	for i := 0; i < 6; i++ {
+		msg, err := r.ReadMessage()
+		c.Assert(err, check.IsNil)
+		c.Check(msg.Gap, check.Equals, false)
+		seqs = append(seqs, msg.Seq)
+	}
+	c.Check(seqs, check.DeepEquals, []uint64{1, 2, 3, 4, 5, 6})
+	c.Check(w.Stats().Readers[0].Capacity, check.Equals, 8)
+
+	// Shrink while the consumer is waiting.
+	done := make(chan Message)
+	go func() {
+		msg, _ := r.ReadMessage()
+		done <- msg
+	}()
+	for atomic.LoadInt32(&r.waiting) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.SetHighWater(1)
+	w.Write([]byte{7})
+	c.Check((<-done).Seq, check.Equals, uint64(7))
+	c.Check(w.Stats().Readers[0].Capacity, check.Equals, 1)
+
+	r.SetLowWater(3)
+	c.Check(atomic.LoadInt32(&r.lowwater), check.Equals, int32(3))
+	w.Close()
+	_, err := r.ReadMessage()
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *Suite) TestSetHighWaterTwice(c *check.C) {
+	w := &Tee{}
+	r := w.NewReader(0, 4)
+	w.Write([]byte("a"))
+	r.SetHighWater(20)
+	w.Write([]byte("b"))
+	r.SetHighWater(30)
+	w.Write([]byte("c"))
+	w.Close()
+	var got []string
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			c.Check(err, check.Equals, io.EOF)
+			break
+		}
+		c.Check(msg.Gap, check.Equals, false)
+		got = append(got, string(msg.Data))
+	}
+	c.Check(got, check.DeepEquals, []string{"a", "b", "c"})
+	c.Check(atomic.LoadInt64(&r.dropped), check.Equals, int64(0))
+	c.Check(atomic.LoadInt64(&r.bufferedBytes), check.Equals, int64(0))
+}
+
+func (s *Suite) TestTryWrite(c *check.C) {
+	w := &Tee{Dedup: 1}
+	delivered, dropped := w.TryWrite([]byte{1})
+	c.Check(delivered, check.Equals, 0)
+	c.Check(dropped, check.Equals, 0)
+	r1 := w.NewReader(0, 1)
+	defer r1.Close()
+	r2 := w.NewReader(0, 4)
+	defer r2.Close()
+	delivered, dropped = w.TryWrite([]byte{2})
+	c.Check(delivered, check.Equals, 2)
+	c.Check(dropped, check.Equals, 0)
+	delivered, dropped = w.TryWrite([]byte{3})
+	c.Check(delivered, check.Equals, 1)
+	c.Check(dropped, check.Equals, 1)
+	// Suppressed duplicate.
+	delivered, dropped = w.TryWrite([]byte{3})
+	c.Check(delivered, check.Equals, 0)
+	c.Check(dropped, check.Equals, 0)
+}
+
+func (s *Suite) TestTryWriteSampled(c *check.C) {
+	w := &Tee{}
+	defer w.Close()
+	r := w.newLiveReader(context.Background(), WithSampleEvery(2))
+	defer r.Close()
+	// r skips odd-numbered writes: neither delivered nor dropped.
+	delivered, dropped := w.TryWrite([]byte{1})
+	c.Check(delivered, check.Equals, 0)
+	c.Check(dropped, check.Equals, 0)
+	delivered, dropped = w.TryWrite([]byte{2})
+	c.Check(delivered, check.Equals, 1)
+	c.Check(dropped, check.Equals, 0)
+}